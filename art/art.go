@@ -0,0 +1,322 @@
+// An adaptive radix tree, after Leis et al, "The Adaptive Radix Tree:
+// ARTful Indexing for Main-Memory Databases". Inner nodes grow from 4
+// to 16, 48 and 256 children as needed, and paths without branches
+// are compressed into the node below them, which suits keys with long
+// shared prefixes. Nodes, keys and values live in a malloc.Region.
+package art
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/malloc"
+)
+
+// Satisfies indexes.Index.
+type Tree struct {
+	region *malloc.Region
+	root   int64
+	size   int64
+}
+
+type Options struct {
+	// Where nodes, keys and values are allocated. Defaults to
+	// malloc.Default.
+	Allocator malloc.Allocator
+}
+
+func NewTree() indexes.Index {
+	return NewTreeOptions(Options{})
+}
+
+func NewTreeOptions(opts Options) indexes.Index {
+	if opts.Allocator == nil {
+		opts.Allocator = malloc.Default
+	}
+	t := &Tree{region: malloc.NewRegion(opts.Allocator)}
+	// so that no reference is 0
+	t.region.Alloc(8)
+	return t
+}
+
+// Length of the common prefix of a and b.
+func common(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Put the key and value under the node at ref, which is depth bytes
+// into key. Returns the node to use in its place, and whether the key
+// was there already. Combines an existing value with value through
+// merge if given, else replaces it.
+func (t *Tree) insert(ref int64, key, value []byte, depth int, merge bool) (int64, bool) {
+	if ref == 0 {
+		return t.newLeaf(key, value), false
+	}
+
+	if kindOf(ref) == kindLeaf {
+		leafKey := t.leafKey(ref)
+		if bytes.Equal(leafKey, key) {
+			if merge {
+				value = append(t.leafValue(ref), value...)
+			}
+			t.setLeafValue(ref, value)
+			return ref, true
+		}
+
+		// a new node where the keys part ways
+		c := common(leafKey[depth:], key[depth:])
+		n := t.newNode(kind4, key[depth:depth+c])
+		n = t.place(n, ref, leafKey, depth+c)
+		n = t.place(n, t.newLeaf(key, value), key, depth+c)
+		return n, false
+	}
+
+	path := t.path(ref)
+	c := common(path, key[depth:])
+	if c < len(path) {
+		// the key leaves the compressed path: split it
+		n := t.newNode(kind4, path[:c])
+		rest := append([]byte{}, path[c+1:]...)
+		n = t.addChild(n, path[c], ref)
+		t.setPath(ref, rest)
+		n = t.place(n, t.newLeaf(key, value), key, depth+c)
+		return n, false
+	}
+	depth += len(path)
+
+	if depth == len(key) {
+		if leaf := t.nodeLeaf(ref); leaf != 0 {
+			_, replaced := t.insert(leaf, key, value, depth, merge)
+			return ref, replaced
+		}
+		t.setNodeLeaf(ref, t.newLeaf(key, value))
+		return ref, false
+	}
+
+	child := t.findChild(ref, key[depth])
+	if child == 0 {
+		return t.addChild(ref, key[depth], t.newLeaf(key, value)), false
+	}
+	newChild, replaced := t.insert(child, key, value, depth+1, merge)
+	if newChild != child {
+		t.replaceChild(ref, key[depth], newChild)
+	}
+	return ref, replaced
+}
+
+// Put a leaf with the given key under node n, which is depth bytes
+// into the key.
+func (t *Tree) place(n, leaf int64, key []byte, depth int) int64 {
+	if depth == len(key) {
+		t.setNodeLeaf(n, leaf)
+		return n
+	}
+	return t.addChild(n, key[depth], leaf)
+}
+
+func checkPut(key, value []byte) {
+	if len(key) == 0 || len(value) == 0 {
+		panic("Illegal nil key or value")
+	}
+}
+
+func (t *Tree) Put(key, value []byte) (replaced bool) {
+	checkPut(key, value)
+	t.root, replaced = t.insert(t.root, key, value, 0, false)
+	if !replaced {
+		t.size++
+	}
+	return
+}
+
+func (t *Tree) Append(key, value []byte) {
+	checkPut(key, value)
+	var replaced bool
+	t.root, replaced = t.insert(t.root, key, value, 0, true)
+	if !replaced {
+		t.size++
+	}
+}
+
+// The leaf for key, or 0.
+func (t *Tree) find(key []byte) int64 {
+	ref, depth := t.root, 0
+	for ref != 0 {
+		if kindOf(ref) == kindLeaf {
+			if bytes.Equal(t.leafKey(ref), key) {
+				return ref
+			}
+			return 0
+		}
+		path := t.path(ref)
+		if !bytes.HasPrefix(key[depth:], path) {
+			return 0
+		}
+		depth += len(path)
+		if depth == len(key) {
+			return t.nodeLeaf(ref)
+		}
+		ref = t.findChild(ref, key[depth])
+		depth++
+	}
+	return 0
+}
+
+func (t *Tree) Get(key []byte) (value []byte, ok bool) {
+	leaf := t.find(key)
+	if leaf == 0 {
+		return nil, false
+	}
+	return t.leafValue(leaf), true
+}
+
+// The node under which all keys start with prefix, or 0 if there are
+// none.
+func (t *Tree) findPrefix(prefix []byte) int64 {
+	ref, depth := t.root, 0
+	for ref != 0 {
+		if kindOf(ref) == kindLeaf {
+			if bytes.HasPrefix(t.leafKey(ref), prefix) {
+				return ref
+			}
+			return 0
+		}
+		path := t.path(ref)
+		rest := prefix[depth:]
+		if len(rest) <= len(path) {
+			if bytes.HasPrefix(path, rest) {
+				return ref
+			}
+			return 0
+		}
+		if !bytes.HasPrefix(rest, path) {
+			return 0
+		}
+		depth += len(path)
+		ref = t.findChild(ref, prefix[depth])
+		depth++
+	}
+	return 0
+}
+
+type frame struct {
+	ref int64
+	// -1 before the node's own leaf, otherwise where the next
+	// child is, see childrenFrom
+	pos int
+}
+
+// Depth first, in key order.
+type iter struct {
+	t     *Tree
+	stack []frame
+}
+
+func (t *Tree) Start(prefix []byte) indexes.Iter {
+	it := &iter{t, make([]frame, 0, 16)}
+	if ref := t.findPrefix(prefix); ref != 0 {
+		it.stack = append(it.stack, frame{ref, -1})
+	}
+	return it
+}
+
+func (it *iter) Next() (key []byte, value []byte, ok bool) {
+	for len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		ref := top.ref
+		if kindOf(ref) == kindLeaf {
+			it.stack = it.stack[:len(it.stack)-1]
+			return it.t.leafKey(ref), it.t.leafValue(ref), true
+		}
+
+		if top.pos < 0 {
+			top.pos = 0
+			if leaf := it.t.nodeLeaf(ref); leaf != 0 {
+				return it.t.leafKey(leaf), it.t.leafValue(leaf), true
+			}
+		}
+
+		found := false
+		it.t.childrenFrom(ref, top.pos, func(c byte, child int64, next int) bool {
+			top.pos = next
+			it.stack = append(it.stack, frame{child, -1})
+			found = true
+			return false
+		})
+		if !found {
+			it.stack = it.stack[:len(it.stack)-1]
+		}
+	}
+	return nil, nil, false
+}
+
+func (t *Tree) Size() int64 {
+	return t.size
+}
+
+// Bytes allocated for nodes, keys and values.
+func (t *Tree) RegionBytes() int64 {
+	return t.region.Size()
+}
+
+// Check that every leaf is where its key says it should be, that
+// nodes have as many children as they say, and that the tree has Size
+// keys.
+func (t *Tree) CheckConsistency() error {
+	count := int64(0)
+	var check func(ref int64, prefix []byte) error
+	check = func(ref int64, prefix []byte) error {
+		if kindOf(ref) == kindLeaf {
+			count++
+			if !bytes.HasPrefix(t.leafKey(ref), prefix) {
+				return fmt.Errorf("leaf %v under %v", t.leafKey(ref), prefix)
+			}
+			return nil
+		}
+
+		prefix = append(prefix, t.path(ref)...)
+		if leaf := t.nodeLeaf(ref); leaf != 0 {
+			if !bytes.Equal(t.leafKey(leaf), prefix) {
+				return fmt.Errorf("leaf %v ends at %v", t.leafKey(leaf), prefix)
+			}
+			count++
+		}
+
+		n := 0
+		var err error
+		t.eachChild(ref, func(c byte, child int64) bool {
+			n++
+			err = check(child, append(prefix[:len(prefix):len(prefix)], c))
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		if n != t.numChildren(ref) {
+			return fmt.Errorf("node %v has %d children, says %d", prefix, n, t.numChildren(ref))
+		}
+		if n == 0 || n == 1 && t.nodeLeaf(ref) == 0 {
+			return fmt.Errorf("node %v has nothing to branch between", prefix)
+		}
+		return nil
+	}
+
+	if t.root != 0 {
+		if err := check(t.root, nil); err != nil {
+			return err
+		}
+	}
+	if count != t.size {
+		return fmt.Errorf("expected %d keys, found %d", t.size, count)
+	}
+	return nil
+}
+
+func (t *Tree) Dispose() {
+	t.region.Dispose()
+}
@@ -0,0 +1,164 @@
+package art
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/indexestest"
+	"github.com/avisagie/indexes/malloc"
+)
+
+var conformance = indexestest.Options{
+	Check: func(index indexes.ROIndex) error {
+		return index.(*Tree).CheckConsistency()
+	},
+}
+
+func TestConformance(t *testing.T) {
+	indexestest.Run(t, NewTree, conformance)
+}
+
+func FuzzTree(f *testing.F) {
+	indexestest.Fuzz(f, NewTree, conformance)
+}
+
+// Every node size, and growing from one to the next.
+func TestNodeSizes(t *testing.T) {
+	for _, n := range []int{1, 2, 4, 5, 16, 17, 48, 49, 256} {
+		index := NewTree().(*Tree)
+		for _, c := range rand.Perm(256)[:n] {
+			k := []byte{'x', 'y', byte(c), 'z'}
+			index.Put(k, k)
+		}
+		// a key that ends where the others branch
+		index.Put([]byte("xy"), []byte("xy"))
+
+		if err := index.CheckConsistency(); err != nil {
+			t.Fatal(n, err)
+		}
+		want := map[int]kind{1: kind4, 2: kind4, 4: kind4, 5: kind16, 16: kind16, 17: kind48, 48: kind48, 49: kind256, 256: kind256}[n]
+		if n > 1 && kindOf(index.root) != want {
+			t.Error("Expected node kind", want, "for", n, "children, got", kindOf(index.root))
+		}
+
+		it := index.Start([]byte("xy"))
+		var prev []byte
+		count := 0
+		for {
+			k, v, ok := it.Next()
+			if !ok {
+				break
+			}
+			if prev != nil && bytes.Compare(prev, k) >= 0 || !bytes.Equal(k, v) {
+				t.Fatal("Out of order or wrong value:", prev, k, v)
+			}
+			prev = append(prev[:0], k...)
+			count++
+		}
+		if count != n+1 {
+			t.Fatal("Expected", n+1, "keys, got", count)
+		}
+		index.Dispose()
+	}
+}
+
+// Keys with long shared prefixes, like encoded tuples.
+func tupleKey(i int) []byte {
+	k := []byte("events/2014-05-01/host-")
+	k = append(k, byte('a'+i%7))
+	k = binary.BigEndian.AppendUint32(k, uint32(i/7))
+	return append(k, "/status"...)
+}
+
+func TestSharedPrefixes(t *testing.T) {
+	index := NewTree().(*Tree)
+	defer index.Dispose()
+	for _, i := range rand.Perm(50000) {
+		index.Put(tupleKey(i), []byte{byte(i)})
+	}
+	if err := index.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50000; i++ {
+		if v, ok := index.Get(tupleKey(i)); !ok || v[0] != byte(i) {
+			t.Fatal("Expected", byte(i), "for", tupleKey(i), "got", v, ok)
+		}
+	}
+	if _, ok := index.Get([]byte("events/2014-05-01/host-a")); ok {
+		t.Fatal("Did not expect a prefix of keys to be found")
+	}
+
+	it := index.Start([]byte("events/2014-05-01/host-c"))
+	count := 0
+	for {
+		k, _, ok := it.Next()
+		if !ok {
+			break
+		}
+		if k[len("events/2014-05-01/host-")] != 'c' {
+			t.Fatal("Unexpected", string(k))
+		}
+		count++
+	}
+	// i%7 == 2
+	if count != (50000+4)/7 {
+		t.Fatal("Expected", (50000+4)/7, "got", count)
+	}
+}
+
+func TestDisposeFreesEverything(t *testing.T) {
+	alloc := malloc.NewDebug(malloc.Default)
+	index := NewTreeOptions(Options{Allocator: alloc})
+	for _, i := range rand.Perm(100000) {
+		index.Put(tupleKey(i), tupleKey(i))
+		index.Append(tupleKey(i/2), []byte{1})
+	}
+	index.Put([]byte{1}, make([]byte, 2*malloc.RegionChunkSize))
+	index.Put([]byte{1}, []byte{1})
+
+	index.Dispose()
+	if count, bytes := alloc.Live(); count != 0 {
+		alloc.Report(os.Stderr)
+		t.Fatal("Leaked", count, "allocations,", bytes, "bytes")
+	}
+}
+
+func benchmarkPut(b *testing.B, newIndex func() indexes.Index) {
+	index := newIndex()
+	defer index.Dispose()
+	keys := make([][]byte, 1<<20)
+	for i := range keys {
+		keys[i] = tupleKey(int(uint32(i) * 2654435761 % (1 << 20)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Put(keys[i%len(keys)], keys[i%len(keys)])
+	}
+}
+
+func benchmarkGet(b *testing.B, newIndex func() indexes.Index) {
+	index := newIndex()
+	defer index.Dispose()
+	keys := make([][]byte, 1<<18)
+	for i := range keys {
+		keys[i] = tupleKey(i)
+		index.Put(keys[i], keys[i])
+	}
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Get(keys[r.Intn(len(keys))])
+	}
+}
+
+func BenchmarkPut(b *testing.B) { benchmarkPut(b, NewTree) }
+func BenchmarkGet(b *testing.B) { benchmarkGet(b, NewTree) }
+
+// The same, for comparison.
+func BenchmarkBtreePut(b *testing.B) { benchmarkPut(b, btree.NewInMemoryBtree) }
+func BenchmarkBtreeGet(b *testing.B) { benchmarkGet(b, btree.NewInMemoryBtree) }
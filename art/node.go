@@ -0,0 +1,318 @@
+package art
+
+import (
+	"encoding/binary"
+)
+
+// References to nodes carry the kind of node in their low bits, which
+// are free since the region aligns everything to 8 bytes. 0 is no
+// node.
+type kind int64
+
+const (
+	kindLeaf kind = iota + 1
+	kind4
+	kind16
+	kind48
+	kind256
+
+	kindMask = 7
+)
+
+func kindOf(ref int64) kind {
+	return kind(ref & kindMask)
+}
+
+func addr(ref int64) int64 {
+	return ref &^ kindMask
+}
+
+// Leaves are:
+//
+//	int64   reference to the value: a uvarint length and the bytes
+//	uint32  length of the key
+//	        the key
+//
+// Inner nodes start with a header:
+//
+//	int64   reference to the compressed path, the bytes that every
+//	        key under the node has in common after its parent's byte
+//	uint32  length of the compressed path
+//	uint16  number of children
+//	        padding
+//	int64   leaf of the key that ends at this node, or 0
+//
+// followed by, for node4 and node16, the bytes of the children in
+// order and then their references; for node48, a byte per possible
+// child byte that is 0 or the position of the child plus one, and the
+// references; for node256 a reference per possible byte.
+const (
+	leafValue   = 0
+	leafKeyLen  = 8
+	leafKey     = 12
+	pathRef     = 0
+	pathLen     = 8
+	numChildren = 12
+	nodeLeaf    = 16
+	headerSize  = 24
+)
+
+var nodeSizes = [...]int{
+	kind4:   headerSize + 8 + 4*8,
+	kind16:  headerSize + 16 + 16*8,
+	kind48:  headerSize + 256 + 48*8,
+	kind256: headerSize + 256*8,
+}
+
+var capacities = [...]int{
+	kind4:   4,
+	kind16:  16,
+	kind48:  48,
+	kind256: 256,
+}
+
+// Where the child references start.
+var childOffsets = [...]int{
+	kind4:   headerSize + 8,
+	kind16:  headerSize + 16,
+	kind48:  headerSize + 256,
+	kind256: headerSize,
+}
+
+func getInt64(b []byte, off int) int64 {
+	return int64(binary.LittleEndian.Uint64(b[off:]))
+}
+
+func putInt64(b []byte, off int, v int64) {
+	binary.LittleEndian.PutUint64(b[off:], uint64(v))
+}
+
+func (t *Tree) node(ref int64) []byte {
+	return t.region.Bytes(addr(ref), nodeSizes[kindOf(ref)])
+}
+
+func (t *Tree) leafKey(ref int64) []byte {
+	hdr := t.region.Bytes(addr(ref), leafKey)
+	l := int(binary.LittleEndian.Uint32(hdr[leafKeyLen:]))
+	return t.region.Bytes(addr(ref)+leafKey, l)
+}
+
+func (t *Tree) leafValue(ref int64) []byte {
+	vref := getInt64(t.region.Bytes(addr(ref), 8), leafValue)
+	l, n := binary.Uvarint(t.region.Bytes(vref, binary.MaxVarintLen64))
+	return t.region.Bytes(vref+int64(n), int(l))
+}
+
+func valueSize(value []byte) int {
+	var hdr [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(hdr[:], uint64(len(value))) + len(value)
+}
+
+// Set a leaf's value. The old one's space is not given back, since
+// what Get and iterators returned may still point at it; it goes
+// with the rest of the region on Dispose.
+func (t *Tree) setLeafValue(ref int64, value []byte) {
+	hdr := t.region.Bytes(addr(ref), 8)
+	var lbuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lbuf[:], uint64(len(value)))
+	size := t.valueAllocSize(value)
+	vref := t.region.Alloc(size)
+	buf := t.region.Bytes(vref, n+len(value))
+	copy(buf, lbuf[:n])
+	copy(buf[n:], value)
+	putInt64(hdr, leafValue, vref)
+}
+
+// Values get room to read a whole uvarint back, however short.
+func (t *Tree) valueAllocSize(value []byte) int {
+	return binary.MaxVarintLen64 + len(value)
+}
+
+func (t *Tree) newLeaf(key, value []byte) int64 {
+	ref := t.region.Alloc(leafKey + len(key))
+	b := t.region.Bytes(ref, leafKey+len(key))
+	putInt64(b, leafValue, 0)
+	binary.LittleEndian.PutUint32(b[leafKeyLen:], uint32(len(key)))
+	copy(b[leafKey:], key)
+	ref |= int64(kindLeaf)
+	t.setLeafValue(ref, value)
+	return ref
+}
+
+// A new, empty inner node with the given compressed path.
+func (t *Tree) newNode(k kind, path []byte) int64 {
+	ref := t.region.Alloc(nodeSizes[k]) | int64(k)
+	b := t.node(ref)
+	for i := range b {
+		b[i] = 0
+	}
+	t.setPath(ref, path)
+	return ref
+}
+
+func (t *Tree) freeNode(ref int64) {
+	t.setPath(ref, nil)
+	t.region.Free(addr(ref), nodeSizes[kindOf(ref)])
+}
+
+func (t *Tree) path(ref int64) []byte {
+	b := t.node(ref)
+	l := int(binary.LittleEndian.Uint32(b[pathLen:]))
+	if l == 0 {
+		return nil
+	}
+	return t.region.Bytes(getInt64(b, pathRef), l)
+}
+
+// Replace a node's compressed path.
+func (t *Tree) setPath(ref int64, path []byte) {
+	b := t.node(ref)
+	if l := int(binary.LittleEndian.Uint32(b[pathLen:])); l > 0 {
+		t.region.Free(getInt64(b, pathRef), l)
+	}
+	binary.LittleEndian.PutUint32(b[pathLen:], uint32(len(path)))
+	if len(path) > 0 {
+		pref := t.region.Alloc(len(path))
+		copy(t.region.Bytes(pref, len(path)), path)
+		putInt64(b, pathRef, pref)
+	}
+}
+
+func (t *Tree) numChildren(ref int64) int {
+	return int(binary.LittleEndian.Uint16(t.node(ref)[numChildren:]))
+}
+
+func (t *Tree) nodeLeaf(ref int64) int64 {
+	return getInt64(t.node(ref), nodeLeaf)
+}
+
+func (t *Tree) setNodeLeaf(ref, leaf int64) {
+	putInt64(t.node(ref), nodeLeaf, leaf)
+}
+
+// The child for byte c, or 0.
+func (t *Tree) findChild(ref int64, c byte) int64 {
+	b := t.node(ref)
+	k := kindOf(ref)
+	children := childOffsets[k]
+	switch k {
+	case kind4, kind16:
+		n := t.numChildren(ref)
+		for i, key := range b[headerSize : headerSize+n] {
+			if key == c {
+				return getInt64(b, children+8*i)
+			}
+		}
+		return 0
+	case kind48:
+		if pos := b[headerSize+int(c)]; pos > 0 {
+			return getInt64(b, children+8*int(pos-1))
+		}
+		return 0
+	default:
+		return getInt64(b, children+8*int(c))
+	}
+}
+
+// Replace the child for byte c, which is there.
+func (t *Tree) replaceChild(ref int64, c byte, child int64) {
+	b := t.node(ref)
+	k := kindOf(ref)
+	children := childOffsets[k]
+	switch k {
+	case kind4, kind16:
+		for i, key := range b[headerSize : headerSize+t.numChildren(ref)] {
+			if key == c {
+				putInt64(b, children+8*i, child)
+				return
+			}
+		}
+	case kind48:
+		putInt64(b, children+8*int(b[headerSize+int(c)]-1), child)
+	default:
+		putInt64(b, children+8*int(c), child)
+	}
+}
+
+// Add a child for byte c, which is not there yet. Grows the node if
+// it is full, so returns the node to use from now on.
+func (t *Tree) addChild(ref int64, c byte, child int64) int64 {
+	n := t.numChildren(ref)
+	if n == capacities[kindOf(ref)] {
+		ref = t.grow(ref)
+	}
+
+	b := t.node(ref)
+	k := kindOf(ref)
+	children := childOffsets[k]
+	switch k {
+	case kind4, kind16:
+		// keep the bytes in order
+		i := 0
+		for i < n && b[headerSize+i] < c {
+			i++
+		}
+		copy(b[headerSize+i+1:headerSize+n+1], b[headerSize+i:headerSize+n])
+		copy(b[children+8*(i+1):children+8*(n+1)], b[children+8*i:children+8*n])
+		b[headerSize+i] = c
+		putInt64(b, children+8*i, child)
+	case kind48:
+		b[headerSize+int(c)] = byte(n + 1)
+		putInt64(b, children+8*n, child)
+	default:
+		putInt64(b, children+8*int(c), child)
+	}
+	binary.LittleEndian.PutUint16(b[numChildren:], uint16(n+1))
+	return ref
+}
+
+// Move the children of a full node to a node of the next size up,
+// and free it.
+func (t *Tree) grow(ref int64) int64 {
+	bigger := t.newNode(kindOf(ref)+1, t.path(ref))
+	t.setNodeLeaf(bigger, t.nodeLeaf(ref))
+	t.eachChild(ref, func(c byte, child int64) bool {
+		bigger = t.addChild(bigger, c, child)
+		return true
+	})
+	t.freeNode(ref)
+	return bigger
+}
+
+// Call f with each child in byte order, until it returns false.
+func (t *Tree) eachChild(ref int64, f func(c byte, child int64) bool) {
+	t.childrenFrom(ref, 0, func(c byte, child int64, _ int) bool { return f(c, child) })
+}
+
+// Call f with the children from position pos on, in byte order, and
+// the position after each. Positions are slots in node4 and node16,
+// and bytes in node48 and node256.
+func (t *Tree) childrenFrom(ref int64, pos int, f func(c byte, child int64, next int) bool) {
+	b := t.node(ref)
+	k := kindOf(ref)
+	children := childOffsets[k]
+	switch k {
+	case kind4, kind16:
+		for i := pos; i < t.numChildren(ref); i++ {
+			if !f(b[headerSize+i], getInt64(b, children+8*i), i+1) {
+				return
+			}
+		}
+	case kind48:
+		for c := pos; c < 256; c++ {
+			if p := b[headerSize+c]; p > 0 {
+				if !f(byte(c), getInt64(b, children+8*int(p-1)), c+1) {
+					return
+				}
+			}
+		}
+	default:
+		for c := pos; c < 256; c++ {
+			if child := getInt64(b, children+8*c); child != 0 {
+				if !f(byte(c), child, c+1) {
+					return
+				}
+			}
+		}
+	}
+}
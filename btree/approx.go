@@ -0,0 +1,130 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Estimates of range sizes in the spirit of LevelDB's
+// GetApproximateSizes. They only read internal nodes: the counts
+// give the number of keys under each separator, and within the
+// lowest internal level the position of a key is interpolated
+// between the separators around it. Leaf pages are never read.
+
+// Estimated number of keys smaller than key.
+func (b *Btree) approximateRank(key []byte) float64 {
+	rank := 0.0
+	var lo, hi []byte
+
+	page := b.pager.Get(b.root)
+	for level := 1; ; level++ {
+		k, _ := page.Search(key)
+		pos := k.Pos()
+		for i := 0; i < pos; i++ {
+			rank += float64(page.Count(i))
+		}
+
+		// narrow the bounds of the subtree we are in
+		prevLo := lo
+		if pos > 0 {
+			lo = k.Get()
+		}
+		if pos+1 < page.Size() {
+			hi, _ = page.GetKey(pos + 1)
+		}
+
+		if level < b.height {
+			page = b.pager.Get(k.Ref())
+			continue
+		}
+
+		// the next level is leaves
+		count := float64(page.Count(pos))
+		if hi != nil || pos == 0 {
+			return rank + interpolate(lo, hi, key)*count
+		}
+
+		// Nothing bounds the last subtree, so assume its keys are
+		// as dense as those in the one before it.
+		if pos > 1 {
+			prevLo, _ = page.GetKey(pos - 1)
+		}
+		prevCount := float64(page.Count(pos - 1))
+		common := commonPrefix(prevLo, lo)
+		if !bytes.HasPrefix(key, lo[:common]) {
+			// past everything with the prefix
+			return rank + count
+		}
+		l, p := float64(keyBits(lo, common)), float64(keyBits(prevLo, common))
+		if prevCount == 0 || l <= p {
+			return rank + count/2
+		}
+		width := (l - p) * count / prevCount
+		f := math.Max(0, math.Min(1, (float64(keyBits(key, common))-l)/width))
+		return rank + f*count
+	}
+}
+
+// Where key falls between lo and hi, from 0 to 1. A nil hi means
+// after everything. Looks at 8 bytes after the prefix lo and hi have
+// in common.
+func interpolate(lo, hi, key []byte) float64 {
+	common := 0
+	if hi != nil {
+		common = commonPrefix(lo, hi)
+	}
+
+	l := float64(keyBits(lo, common))
+	h := math.MaxUint64 * 1.0
+	if hi != nil {
+		h = float64(keyBits(hi, common))
+	}
+	k := float64(keyBits(key, common))
+	if h <= l {
+		return 0.5
+	}
+	return math.Max(0, math.Min(1, (k-l)/(h-l)))
+}
+
+func commonPrefix(a, b []byte) (n int) {
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return
+}
+
+// 8 bytes of key starting at offset as a big endian number, padded
+// with zeros.
+func keyBits(key []byte, offset int) uint64 {
+	var buf [8]byte
+	if offset < len(key) {
+		copy(buf[:], key[offset:])
+	}
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// Estimated number of keys k with start <= k < end. A nil end means
+// no upper bound.
+func (b *Btree) ApproximateCount(start, end []byte) int64 {
+	last := float64(b.Size())
+	if end != nil {
+		last = b.approximateRank(end)
+	}
+	count := last - b.approximateRank(start)
+	if count < 0 {
+		return 0
+	}
+	return int64(count + 0.5)
+}
+
+// Estimated number of bytes taken by the keys k with start <= k <
+// end and their values, using the average over the whole tree.
+func (b *Btree) ApproximateBytes(start, end []byte) int64 {
+	if b.Size() == 0 {
+		return 0
+	}
+	usage := b.MemoryUsage()
+	perKey := float64(usage.PageBytes+usage.ValueStoreBytes) / float64(b.Size())
+	return int64(float64(b.ApproximateCount(start, end)) * perKey)
+}
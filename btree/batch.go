@@ -0,0 +1,65 @@
+package btree
+
+import (
+	"sort"
+)
+
+// Batches of keys are done in key order, so that neighbouring keys
+// that land in the same leaf share the descent from the root.
+
+// Indexes of keys in key order. Equal keys keep their order.
+func keyOrder(keys [][]byte) []int {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return keyLess(keys[order[i]], keys[order[j]])
+	})
+	return order
+}
+
+// Get many keys at once. values[i] and found[i] are for keys[i].
+func (b *Btree) GetBatch(keys [][]byte) (values [][]byte, found []bool) {
+	for _, key := range keys {
+		if len(key) == 0 {
+			panic("Illegal key nil")
+		}
+	}
+
+	values = make([][]byte, len(keys))
+	found = make([]bool, len(keys))
+	var c cursor
+	for _, i := range keyOrder(keys) {
+		k, ok := b.seek(&c, keys[i])
+		if ok {
+			values[i] = b.pager.Get(c.leaf()).GetValue(k.Ref())
+			found[i] = true
+		}
+	}
+	return
+}
+
+// Put many keys at once. replaced[i] is what Put would have returned
+// for keys[i] and values[i] if they were put in the order given, so
+// the last of equal keys wins.
+func (b *Btree) PutBatch(keys, values [][]byte) (replaced []bool) {
+	if len(keys) != len(values) {
+		panic("Expected as many values as keys")
+	}
+	for i, key := range keys {
+		if len(key) == 0 || len(values[i]) == 0 {
+			panic("Illegal nil key or value")
+		}
+		if err := CheckKey(key); err != nil {
+			panic(err)
+		}
+	}
+
+	replaced = make([]bool, len(keys))
+	var c cursor
+	for _, i := range keyOrder(keys) {
+		replaced[i] = b.put(&c, keys[i], values[i])
+	}
+	return
+}
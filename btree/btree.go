@@ -4,17 +4,50 @@
 package btree
 
 import (
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/malloc"
 )
 
+// Returned by CheckKey for keys longer than MaxKeySize. Put, Append
+// and PutNext panic with it.
+var ErrKeyTooLarge = errors.New("btree: key larger than MaxKeySize")
+
+// Check that a key can go into a Btree.
+func CheckKey(key []byte) error {
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
 // B+ Tree. Consists of pages. Satisfies indexes.Index.
 type Btree struct {
 	pager Pager
-	root  int
+	root  int64
 	size  int64
+	// number of levels of internal nodes
+	height int
+
+	appendFunc  func(old, value []byte) []byte
+	splitPolicy SplitPolicy
+	alloc       malloc.Allocator
+
+	budget        int64
+	onBudget      func(MemoryUsage)
+	budgetReached bool
+
+	// The path to the leaf Put wrote to last, so that runs of
+	// nearby keys skip the search from the root. Every split
+	// invalidates it.
+	finger cursor
+	// Whether the last Put went into the rightmost leaf, and how
+	// many in a row went after everything in the tree.
+	lastRightmost bool
+	ascending     int
 }
 
 type btreeIter struct {
@@ -55,12 +88,79 @@ func (i *btreeIter) Next() (key []byte, value []byte, ok bool) {
 	return key, i.page.GetValue(ref), ok
 }
 
+// Options for NewInMemoryBtreeOptions. The zero value gives the
+// defaults.
+type Options struct {
+	// Where pages and values are allocated. Defaults to
+	// malloc.Default.
+	Allocator malloc.Allocator
+
+	// Once the tree uses this many bytes BudgetReached returns
+	// true and OnBudgetReached is called, so that the caller knows
+	// to stop and flush it. Zero means no budget.
+	MemoryBudget int64
+
+	// Called once, from the Put, Append or PutNext that reached
+	// MemoryBudget.
+	OnBudgetReached func(MemoryUsage)
+
+	// How Append combines the value already under a key with the
+	// new one. Defaults to concatenating them. old must not be
+	// changed: it points into the tree.
+	AppendFunc func(old, value []byte) []byte
+
+	// Where full pages split. Defaults to SplitByCount.
+	SplitPolicy SplitPolicy
+}
+
+// Memory used by a Btree, kept up to date as it grows.
+type MemoryUsage struct {
+	PageBytes       int64
+	ValueStoreBytes int64
+	ScratchBytes    int64
+
+	// Of ValueStoreBytes, what is allocated but not written to yet.
+	ValueStoreSlack int64
+}
+
+func (m MemoryUsage) Total() int64 {
+	return m.PageBytes + m.ValueStoreBytes + m.ScratchBytes
+}
+
+// Bytes holding keys and values, leaving out the scratch page and
+// the unused end of the value store.
+func (m MemoryUsage) Used() int64 {
+	return m.PageBytes + m.ValueStoreBytes - m.ValueStoreSlack
+}
+
 func NewInMemoryBtree() indexes.Index {
-	bt := &Btree{newInplacePager(), 0, 0}
+	return NewInMemoryBtreeOptions(Options{})
+}
+
+func NewInMemoryBtreeOptions(opts Options) indexes.Index {
+	if opts.Allocator == nil {
+		opts.Allocator = malloc.Default
+	}
+	if opts.AppendFunc == nil {
+		opts.AppendFunc = concat
+	}
+	if opts.SplitPolicy == nil {
+		opts.SplitPolicy = SplitByCount
+	}
+	bt := &Btree{
+		pager:    newInplacePager(opts.Allocator),
+		budget:   opts.MemoryBudget,
+		onBudget: opts.OnBudgetReached,
+
+		appendFunc:  opts.AppendFunc,
+		splitPolicy: opts.SplitPolicy,
+		alloc:       opts.Allocator,
+	}
 
 	const internalNode = false
 	ref, root := bt.pager.New(internalNode)
 	bt.root = ref
+	bt.height = 1
 
 	const leafNode = true
 	ref, _ = bt.pager.New(leafNode)
@@ -69,36 +169,74 @@ func NewInMemoryBtree() indexes.Index {
 	return bt
 }
 
-func (b *Btree) search(key []byte) (k Key, pageRefs []int, ok bool) {
-	pageRefs = make([]int, 0, 8)
-	ref := b.root
+// The path from the root to a leaf: the pageRefs we visit searching
+// down the tree and the positions of the keys we followed in them.
+// Also knows which keys belong in the leaf, so that a search for a
+// nearby key can start at the leaf. Only valid until a page splits.
+type cursor struct {
+	pageRefs  []int64
+	positions []int
+	// keys in the leaf are >= lo and < hi. nil hi means no upper
+	// bound. These point into internal pages, which only move
+	// their keys when they split.
+	lo, hi []byte
+	valid  bool
+}
 
-	// keep track of the pageRefs we visit searching down the
-	// tree.
-	pageRefs = append(pageRefs, ref)
+func (c *cursor) contains(key []byte) bool {
+	return c.valid && !keyLess(key, c.lo) && (c.hi == nil || keyLess(key, c.hi))
+}
 
-	for {
-		p := b.pager.Get(ref)
-		k, ok = p.Search(key)
-		ref = k.Ref()
+func (c *cursor) leaf() int64 {
+	return c.pageRefs[len(c.pageRefs)-1]
+}
 
-		// if it is a leaf, we're done
-		if p.IsLeaf() {
-			break
+// Search for key, starting at the cursor's leaf if the key belongs
+// there, else from the root. Leaves the cursor on the key's leaf.
+func (b *Btree) seek(c *cursor, key []byte) (k Key, ok bool) {
+	if !c.contains(key) {
+		c.pageRefs = append(c.pageRefs[:0], b.root)
+		c.positions = c.positions[:0]
+		c.lo, c.hi = nil, nil
+
+		p := b.pager.Get(b.root)
+		for !p.IsLeaf() {
+			k, _ := p.Search(key)
+			pos := k.Pos()
+			if pos > 0 {
+				c.lo = k.Get()
+			}
+			if pos+1 < p.Size() {
+				c.hi, _ = p.GetKey(pos + 1)
+			}
+			c.positions = append(c.positions, pos)
+			c.pageRefs = append(c.pageRefs, k.Ref())
+			p = b.pager.Get(k.Ref())
 		}
-
-		pageRefs = append(pageRefs, ref)
+		c.positions = append(c.positions, -1)
+		c.valid = true
 	}
 
+	k, ok = b.pager.Get(c.leaf()).Search(key)
+	c.positions[len(c.positions)-1] = k.Pos()
 	return
 }
 
+func (b *Btree) search(key []byte) (k Key, pageRefs []int64, positions []int, ok bool) {
+	c := &cursor{
+		pageRefs:  make([]int64, 0, 8),
+		positions: make([]int, 0, 8),
+	}
+	k, ok = b.seek(c, key)
+	return k, c.pageRefs, c.positions, ok
+}
+
 func (b *Btree) Get(key []byte) (value []byte, ok bool) {
 	if len(key) == 0 {
 		panic("Illegal key nil")
 	}
 
-	k, pageRefs, ok := b.search(key)
+	k, pageRefs, _, ok := b.search(key)
 	if ok {
 		page := b.pager.Get(pageRefs[len(pageRefs)-1])
 		value = page.GetValue(k.Ref())
@@ -108,7 +246,7 @@ func (b *Btree) Get(key []byte) (value []byte, ok bool) {
 }
 
 func (b *Btree) Start(prefix []byte) (it indexes.Iter) {
-	_, pageRefs, _ := b.search(prefix)
+	_, pageRefs, _, _ := b.search(prefix)
 
 	ref := pageRefs[len(pageRefs)-1]
 	page := b.pager.Get(ref)
@@ -116,16 +254,63 @@ func (b *Btree) Start(prefix []byte) (it indexes.Iter) {
 	return &btreeIter{prefix, page.Start(prefix), page, b, false}
 }
 
-func (b *Btree) split(key []byte, ref int, pageRefs []int) {
+// Number of keys in the subtree under a page.
+func (b *Btree) pageCount(page Page) (count int64) {
+	if page.IsLeaf() {
+		return int64(page.Size())
+	}
+	for i := 0; i < page.Size(); i++ {
+		count += page.Count(i)
+	}
+	return
+}
+
+// Insert into a page, and in internal nodes also set the count of
+// the subtree that ref refers to.
+func (b *Btree) insert(page Page, key []byte, ref int64, count int64) bool {
+	if !page.Insert(key, ref) {
+		return false
+	}
+	if !page.IsLeaf() {
+		k, _ := page.Search(key)
+		page.SetCount(k.Pos(), count)
+	}
+	return true
+}
+
+// Add to the counts of the keys followed to get to the leaf.
+func (b *Btree) addCounts(pageRefs []int64, positions []int, delta int64) {
+	for i := 0; i < len(pageRefs)-1; i++ {
+		page := b.pager.Get(pageRefs[i])
+		page.SetCount(positions[i], page.Count(positions[i])+delta)
+	}
+}
+
+// Split the last page in pageRefs where policy says and insert the
+// key into it. count is the number of keys under ref if it refers to
+// a page. positions are those returned by search. The parent's count
+// for the page is set from what ends up in the two pages. Parents
+// that fill up split by the tree's policy.
+func (b *Btree) split(key []byte, ref int64, count int64, pageRefs []int64, positions []int, policy SplitPolicy) {
+	b.finger.valid = false
 	pageRef := pageRefs[len(pageRefs)-1]
 	page := b.pager.Get(pageRef)
 
 	parentRef := pageRefs[len(pageRefs)-2]
 	parent := b.pager.Get(parentRef)
+	pos := positions[len(positions)-2]
 
 	// Split the page
 	newPageRef, newPage := b.pager.New(page.IsLeaf())
-	splitKey := page.Split(newPageRef, newPage)
+	splitKey := page.Split(newPageRef, newPage, key, policy)
+	if page.IsLeaf() {
+		// Only keys in the leaves need to fall on the right
+		// side of the separator, so it can be as short as
+		// tells the last on the left from the first on the
+		// right.
+		last, _ := page.GetKey(page.Size() - 1)
+		splitKey = separator(last, splitKey)
+	}
 
 	newPage.SetNextPage(page.NextPage())
 	page.SetNextPage(newPageRef)
@@ -134,12 +319,14 @@ func (b *Btree) split(key []byte, ref int, pageRefs []int) {
 	// must go. Don't bother checking ok, after split there must
 	// be space.
 	if keyLess(key, splitKey) {
-		page.Insert(key, ref)
+		b.insert(page, key, ref, count)
 	} else {
-		newPage.Insert(key, ref)
+		b.insert(newPage, key, ref, count)
 	}
 
-	ok := parent.Insert(splitKey, newPageRef)
+	parent.SetCount(pos, b.pageCount(page))
+	newCount := b.pageCount(newPage)
+	ok := b.insert(parent, splitKey, newPageRef, newCount)
 	if !ok {
 		if parentRef == b.root {
 			if len(pageRefs) != 2 {
@@ -149,9 +336,10 @@ func (b *Btree) split(key []byte, ref int, pageRefs []int) {
 			newRootRef, newRoot := b.pager.New(false)
 			newRoot.SetFirst(oldRootRef)
 			b.root = newRootRef
-			b.split(splitKey, newPageRef, []int{newRootRef, parentRef})
+			b.height++
+			b.split(splitKey, newPageRef, newCount, []int64{newRootRef, parentRef}, []int{0, pos}, b.splitPolicy)
 		} else {
-			b.split(splitKey, newPageRef, pageRefs[:len(pageRefs)-1])
+			b.split(splitKey, newPageRef, newCount, pageRefs[:len(pageRefs)-1], positions[:len(positions)-1], b.splitPolicy)
 		}
 	}
 }
@@ -160,23 +348,59 @@ func (b *Btree) Put(key []byte, valuev []byte) (replaced bool) {
 	if len(key) == 0 || len(valuev) == 0 {
 		panic("Illegal nil key or value")
 	}
+	if err := CheckKey(key); err != nil {
+		panic(err)
+	}
 
-	_, pageRefs, replaced := b.search(key)
-	pageRef := pageRefs[len(pageRefs)-1]
-	page := b.pager.Get(pageRef)
+	return b.put(&b.finger, key, valuev)
+}
+
+// Put with a cursor. Leaves the cursor invalid if a page split.
+//
+// Puts that keep going to the rightmost leaf look like a run of
+// mostly ascending keys. Splitting that leaf in half would leave the
+// left half to the few keys that arrive late, so it keeps most of its
+// keys instead. If the whole leaf was filled in order, nothing is
+// likely to arrive late, and the next key starts a new leaf as
+// PutNext does.
+func (b *Btree) put(c *cursor, key []byte, valuev []byte) (replaced bool) {
+	_, replaced = b.seek(c, key)
+	page := b.pager.Get(c.leaf())
 	if replaced {
 		// TODO do not waste a slot in p.r.values
 		vref := page.InsertValue(valuev)
 		page.Insert(key, vref)
+		b.checkBudget()
 		return true
 	}
 
+	// the leaf's Search finds no key at or after one that goes
+	// after everything in it
+	rightmost := page.NextPage() == -1
+	atEnd := rightmost && c.positions[len(c.positions)-1] < 0
+
+	b.addCounts(c.pageRefs, c.positions, 1)
 	vref := page.InsertValue(valuev)
 	ok := page.Insert(key, vref)
 	if !ok {
-		b.split(key, vref, pageRefs)
+		switch {
+		case atEnd && b.ascending >= page.Size():
+			b.appendPage(key, vref, 0, c.pageRefs, c.positions)
+		case rightmost && b.lastRightmost:
+			b.split(key, vref, 0, c.pageRefs, c.positions, runFill)
+		default:
+			b.split(key, vref, 0, c.pageRefs, c.positions, b.splitPolicy)
+		}
+		c.valid = false
+	}
+	b.lastRightmost = rightmost
+	if atEnd {
+		b.ascending++
+	} else {
+		b.ascending = 0
 	}
 	b.size++
+	b.checkBudget()
 	return
 }
 
@@ -184,8 +408,11 @@ func (b *Btree) Append(key []byte, value []byte) {
 	if len(key) == 0 || len(value) == 0 {
 		panic("Illegal nil key or value")
 	}
+	if err := CheckKey(key); err != nil {
+		panic(err)
+	}
 
-	k, pageRefs, ok := b.search(key)
+	k, pageRefs, _, ok := b.search(key)
 	if ok {
 		pageRef := pageRefs[len(pageRefs)-1]
 		page := b.pager.Get(pageRef)
@@ -195,9 +422,10 @@ func (b *Btree) Append(key []byte, value []byte) {
 
 		// TODO extend Page's InsertValue to be able to append
 		// without having to ditch the old copy.
-		newValue := append(page.GetValue(k.Ref()), value...)
+		newValue := b.appendFunc(page.GetValue(k.Ref()), value)
 		newRef := page.InsertValue(newValue)
 		page.Insert(k.Get(), newRef)
+		b.checkBudget()
 	} else {
 		if replaced := b.Put(key, value); replaced {
 			panic("Did not expect to have to replace the value")
@@ -205,14 +433,52 @@ func (b *Btree) Append(key []byte, value []byte) {
 	}
 }
 
+// The default Options.AppendFunc. old's capacity is its length, so
+// this copies.
+func concat(old, value []byte) []byte {
+	return append(old, value...)
+}
+
 func (b *Btree) Size() int64 {
 	return b.size
 }
 
-// recursively check sorting inside pages and that child pages
-// only have keys that are greater than or equal to the keys
-// that reference them.
-func (b *Btree) checkPage(page Page, checkMinKey bool, minKey []byte, ref int, depth int) error {
+// Memory in use by the tree. Cheap enough to call after every Put.
+func (b *Btree) MemoryUsage() MemoryUsage {
+	return b.pager.MemoryUsage()
+}
+
+// Whether the tree has reached Options.MemoryBudget.
+func (b *Btree) BudgetReached() bool {
+	return b.budgetReached
+}
+
+func (b *Btree) checkBudget() {
+	if b.budget <= 0 || b.budgetReached {
+		return
+	}
+	usage := b.pager.MemoryUsage()
+	if usage.Total() >= b.budget {
+		b.budgetReached = true
+		if b.onBudget != nil {
+			b.onBudget(usage)
+		}
+	}
+}
+
+// recursively check sorting inside pages, and that every key in a
+// page is in [lo, hi), the bounds set by the separators that lead to
+// it. Separators are only as long as it takes to tell the pages on
+// either side apart, so they need not be keys in the tree. nil hi
+// means no upper bound.
+func (b *Btree) checkPage(page Page, lo, hi []byte) error {
+	inBounds := func(k []byte) error {
+		if keyLess(k, lo) || hi != nil && !keyLess(k, hi) {
+			return fmt.Errorf("expect keys in [%v, %v) under their separators, got %v", lo, hi, k)
+		}
+		return nil
+	}
+
 	if page.IsLeaf() {
 		prev := []byte{}
 		for i := 0; i < page.Size(); i++ {
@@ -220,33 +486,44 @@ func (b *Btree) checkPage(page Page, checkMinKey bool, minKey []byte, ref int, d
 			if !keyLess(prev, k) {
 				return fmt.Errorf("expect strict ordering, got violation %v >= %v", prev, k)
 			}
+			if err := inBounds(k); err != nil {
+				return err
+			}
 			if r < 0 {
 				return fmt.Errorf("value reference cannot be < 0")
 			}
 			prev = k
 		}
-	} else {
-		prevk, prevr := page.GetKey(0)
-		if prevr == -1 && page.Size() > 1 {
-			return fmt.Errorf("expected internal node to refer to other pages")
-		}
-		for i := 1; i < page.Size(); i++ {
-			k, r := page.GetKey(i)
-			if checkMinKey && !keyLess(minKey, k) {
-				return fmt.Errorf("expect parent key to be smaller or equal to all in referred to child page: got violation %v >= %v", prevk, minKey)
-			}
-			if !keyLess(prevk, k) {
+		return nil
+	}
+
+	_, prevr := page.GetKey(0)
+	if prevr == -1 && page.Size() > 1 {
+		return fmt.Errorf("expected internal node to refer to other pages")
+	}
+	for i := 0; i < page.Size(); i++ {
+		k, r := page.GetKey(i)
+		childLo := lo
+		if i > 0 {
+			if prevk, _ := page.GetKey(i - 1); i > 1 && !keyLess(prevk, k) {
 				return fmt.Errorf("expect strict ordering, got violation %v >= %v", prevk, k)
 			}
-			if r < 0 {
-				return fmt.Errorf("value reference cannot be < 0")
-			}
-			if err := b.checkPage(b.pager.Get(r), true, k, r, depth+1); err != nil {
+			if err := inBounds(k); err != nil {
 				return err
 			}
+			childLo = k
+		}
+		childHi := hi
+		if i+1 < page.Size() {
+			childHi, _ = page.GetKey(i + 1)
+		}
+		if r < 0 {
+			return fmt.Errorf("value reference cannot be < 0")
+		}
+		if err := b.checkPage(b.pager.Get(r), childLo, childHi); err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
 
@@ -275,35 +552,79 @@ func (b *Btree) CheckConsistency() error {
 	}
 
 	root := b.pager.Get(b.root)
-	return b.checkPage(root, false, []byte{}, 0, 0)
+	if err := b.checkPage(root, []byte{}, nil); err != nil {
+		return err
+	}
+
+	count, err := b.checkCounts(root)
+	if err != nil {
+		return err
+	}
+	if count != b.Size() {
+		return fmt.Errorf("expected the root to count %d keys, got %d", b.Size(), count)
+	}
+	return nil
 }
 
-func (b *Btree) appendPage(key []byte, ref int, pageRefs []int) {
+// recursively check that the counts in internal nodes add up to
+// the number of keys in the leaves.
+func (b *Btree) checkCounts(page Page) (int64, error) {
+	if page.IsLeaf() {
+		return int64(page.Size()), nil
+	}
+	total := int64(0)
+	for i := 0; i < page.Size(); i++ {
+		_, r := page.GetKey(i)
+		count, err := b.checkCounts(b.pager.Get(r))
+		if err != nil {
+			return 0, err
+		}
+		if count != page.Count(i) {
+			return 0, fmt.Errorf("page %d has %d keys, but its parent counts %d", r, count, page.Count(i))
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// Like split, but for keys that go after everything in the tree:
+// starts a new page to the right instead of splitting the full one.
+func (b *Btree) appendPage(key []byte, ref int64, count int64, pageRefs []int64, positions []int) {
+	b.finger.valid = false
 	pageRef := pageRefs[len(pageRefs)-1]
 	page := b.pager.Get(pageRef)
 
 	parentRef := pageRefs[len(pageRefs)-2]
 	parent := b.pager.Get(parentRef)
+	pos := positions[len(positions)-2]
 
 	newPageRef, newPage := b.pager.New(page.IsLeaf())
 	page.SetNextPage(newPageRef)
 
+	// key is a separator already unless this is a leaf
+	sep := key
 	if page.IsLeaf() {
+		last, _ := page.GetKey(page.Size() - 1)
+		sep = separator(last, key)
 		newPage.Insert(key, ref)
 	} else {
 		newPage.SetFirst(ref)
+		newPage.SetCount(0, count)
 	}
 
-	ok := parent.Insert(key, newPageRef)
+	parent.SetCount(pos, b.pageCount(page))
+	newCount := b.pageCount(newPage)
+	ok := b.insert(parent, sep, newPageRef, newCount)
 	if !ok {
 		if parentRef == b.root {
 			newRootRef, newRoot := b.pager.New(false)
 			newRoot.SetFirst(b.root)
 			oldRootRef := b.root
 			b.root = newRootRef
-			b.appendPage(key, newPageRef, []int{newRootRef, oldRootRef})
+			b.height++
+			b.appendPage(sep, newPageRef, newCount, []int64{newRootRef, oldRootRef}, []int{0, pos})
 		} else {
-			b.appendPage(key, newPageRef, pageRefs[:len(pageRefs)-1])
+			b.appendPage(sep, newPageRef, newCount, pageRefs[:len(pageRefs)-1], positions[:len(positions)-1])
 		}
 	}
 }
@@ -315,8 +636,12 @@ func (b *Btree) PutNext(key, value []byte) {
 	if len(key) == 0 || len(value) == 0 {
 		panic("Illegal nil key or value")
 	}
+	if err := CheckKey(key); err != nil {
+		panic(err)
+	}
 
-	pageRefs := make([]int, 0, 8)
+	pageRefs := make([]int64, 0, 8)
+	positions := make([]int, 0, 8)
 	pageRefs = append(pageRefs, b.root)
 	page := b.pager.Get(b.root)
 	for !page.IsLeaf() {
@@ -324,16 +649,26 @@ func (b *Btree) PutNext(key, value []byte) {
 		if !keyLess(k, key) {
 			panic(fmt.Sprint("out of order put:", key))
 		}
+		positions = append(positions, page.Size()-1)
 		page = b.pager.Get(r)
 		pageRefs = append(pageRefs, r)
 	}
+	if page.Size() > 0 {
+		// the separators above only bound the first key in the leaf
+		if k, _ := page.GetKey(page.Size() - 1); !keyLess(k, key) {
+			panic(fmt.Sprint("out of order put:", key))
+		}
+	}
+	positions = append(positions, page.Size())
 
+	b.addCounts(pageRefs, positions, 1)
 	vref := page.InsertValue(value)
 	ok := page.PutNext(key, vref)
 	if !ok {
-		b.appendPage(key, vref, pageRefs)
+		b.appendPage(key, vref, 0, pageRefs, positions)
 	}
 	b.size++
+	b.checkBudget()
 }
 
 func spaces(n int) string {
@@ -344,14 +679,16 @@ func spaces(n int) string {
 	return string(ret)
 }
 
-func (b *Btree) dumpPage(out io.Writer, ref, depth int) {
+func (b *Btree) dumpPage(out io.Writer, ref int64, depth int) {
 	space := spaces(depth)
 	page := b.pager.Get(ref)
 	fmt.Fprintf(out, "%sPage %d, leaf:%v, %d keys:\n", space, ref, page.IsLeaf(), page.Size())
 	for i := 0; i < page.Size(); i++ {
 		k, r := page.GetKey(i)
-		fmt.Fprintf(out, "%s\t%d: %v -> %d\n", space, i, k, r)
-		if !page.IsLeaf() {
+		if page.IsLeaf() {
+			fmt.Fprintf(out, "%s\t%d: %v -> %d\n", space, i, k, r)
+		} else {
+			fmt.Fprintf(out, "%s\t%d: %v -> %d, %d keys\n", space, i, k, r, page.Count(i))
 			b.dumpPage(out, r, depth+1)
 		}
 	}
@@ -5,14 +5,29 @@ import (
 	"encoding/binary"
 	"math/rand"
 	"os"
+	"sort"
 	"testing"
 
 	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/malloc"
 )
 
+// With the mallocdebug build tag, report what the tests leaked, and
+// fail if they leaked anything.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if d, ok := malloc.Default.(*malloc.Debug); ok {
+		if d.Report(os.Stderr) > 0 && code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
 func TestBtreeCreate(t *testing.T) {
 	var index indexes.Index
 	index = NewInMemoryBtree()
+	defer index.Dispose()
 	t.Log(index)
 	if err := index.(*Btree).CheckConsistency(); err != nil {
 		t.Fatal(err)
@@ -42,6 +57,7 @@ func TestKeys(t *testing.T) {
 
 func TestBtreeSearchEmpty(t *testing.T) {
 	index := NewInMemoryBtree()
+	defer index.Dispose()
 	value, ok := index.Get([]byte{1, 2, 3})
 	if ok {
 		t.Error("Did not expect to find anything")
@@ -56,6 +72,7 @@ func TestBtreeSearchEmpty(t *testing.T) {
 
 func TestBtreeInsert1(t *testing.T) {
 	index := NewInMemoryBtree()
+	defer index.Dispose()
 	value, ok := index.Get([]byte{1, 2, 3})
 	if ok {
 		t.Fatal("Did not expect to find anything")
@@ -117,6 +134,7 @@ func TestBtreeInsert1(t *testing.T) {
 
 func TestBtreeAppend(t *testing.T) {
 	index := NewInMemoryBtree()
+	defer index.Dispose()
 	index.Put([]byte{1, 2, 3}, []byte{4, 5, 6})
 	index.Put([]byte{1, 2, 1}, []byte{6, 5, 4})
 	index.Append([]byte{1, 2, 3}, []byte{7, 8, 9})
@@ -136,6 +154,7 @@ func TestBtreeAppend(t *testing.T) {
 
 func TestBtreeOverride(t *testing.T) {
 	index := NewInMemoryBtree()
+	defer index.Dispose()
 	value, ok := index.Get([]byte{1, 2, 3})
 	if ok {
 		t.Fatal("Did not expect to find anything")
@@ -167,6 +186,7 @@ func TestPrefixMatches(t *testing.T) {
 
 func TestShortIter(t *testing.T) {
 	index := NewInMemoryBtree()
+	defer index.Dispose()
 	index.Put([]byte{1, 2, 3}, []byte{1, 2, 3})
 	index.Put([]byte{1, 2, 4}, []byte{1, 2, 3})
 	index.Put([]byte{1, 2, 5}, []byte{1, 2, 3})
@@ -270,10 +290,21 @@ func fill(t *testing.T, index indexes.Index) (keys [][]byte) {
 }
 
 func BenchmarkBulkLoadUnordered(b *testing.B) {
+	bulkLoadUnordered(b)
+}
+
+func BenchmarkBulkLoadUnorderedNoPrefixes(b *testing.B) {
+	findWithPrefixes = false
+	defer func() { findWithPrefixes = true }()
+	bulkLoadUnordered(b)
+}
+
+func bulkLoadUnordered(b *testing.B) {
 	buffer := &bytes.Buffer{}
 	count := 0
 
 	index := NewInMemoryBtree()
+	defer index.Dispose()
 	keys := make([][]byte, 0)
 
 	for ; count < b.N; count++ {
@@ -320,6 +351,7 @@ func BenchmarkBulkLoadOrdered(b *testing.B) {
 
 func TestLarger(t *testing.T) {
 	index := NewInMemoryBtree()
+	defer index.Dispose()
 	keys := fill(t, index)
 
 	// iteration
@@ -347,6 +379,7 @@ func TestLarger(t *testing.T) {
 
 func TestIteration2(t *testing.T) {
 	index := NewInMemoryBtree()
+	defer index.Dispose()
 	fill(t, index)
 
 	iter := index.Start([]byte{4})
@@ -364,9 +397,11 @@ func TestIteration2(t *testing.T) {
 
 func TestBulk(t *testing.T) {
 	index1 := NewInMemoryBtree()
+	defer index1.Dispose()
 	fill(t, index1)
 
 	index2 := NewInMemoryBtree()
+	defer index2.Dispose()
 	bt := index2.(*Btree)
 
 	iter := index1.Start([]byte{})
@@ -399,3 +434,447 @@ func TestBulk(t *testing.T) {
 	t.Log("Bulk filled used pages:", len(bt.pager.(*inplacePager).pages))
 	t.Log("Random filled used pages:", len(index1.(*Btree).pager.(*inplacePager).pages))
 }
+
+func TestLargeValues(t *testing.T) {
+	index := NewInMemoryBtree()
+	defer index.Dispose()
+
+	values := make(map[string][]byte)
+	for i, size := range []int{1, 4095, 4097, 70000, bufSize - 1, bufSize, 3 * bufSize, 100} {
+		k := []byte{byte(i), 1}
+		v := make([]byte, size)
+		for j := range v {
+			v[j] = byte(j * (i + 1))
+		}
+		index.Put(k, v)
+		values[string(k)] = v
+	}
+
+	// grow one value past a buffer by appending to it
+	k := []byte{0xff}
+	index.Put(k, []byte{1})
+	expected := []byte{1}
+	chunk := bytes.Repeat([]byte{7, 8, 9}, 100000)
+	for i := 0; i < 5; i++ {
+		index.Append(k, chunk)
+		expected = append(expected, chunk...)
+	}
+	values[string(k)] = expected
+
+	for k, v := range values {
+		got, ok := index.Get([]byte(k))
+		if !ok || !bytes.Equal(got, v) {
+			t.Fatal("Wrong value for", []byte(k), "expected", len(v), "bytes, got", len(got), ok)
+		}
+	}
+
+	if err := index.(*Btree).CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLargeKeys(t *testing.T) {
+	index := NewInMemoryBtree()
+	defer index.Dispose()
+
+	keys := make([][]byte, 0)
+	for i := 0; i < 2000; i++ {
+		size := 4 + rand.Intn(MaxKeySize-4)
+		if i%10 == 0 {
+			size = MaxKeySize
+		}
+		k := make([]byte, size)
+		rand.Read(k)
+		keys = append(keys, k)
+		index.Put(k, k[:4])
+	}
+
+	for _, k := range keys {
+		v, ok := index.Get(k)
+		if !ok || !bytes.Equal(v, k[:4]) {
+			t.Fatal("Expected", k[:4], "got", v, "ok =", ok)
+		}
+	}
+
+	if err := index.(*Btree).CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+
+	bt := NewInMemoryBtree().(*Btree)
+	defer bt.Dispose()
+	iter := index.Start([]byte{})
+	for {
+		k, v, ok := iter.Next()
+		if !ok {
+			break
+		}
+		bt.PutNext(k, v)
+	}
+	if err := bt.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+	if bt.Size() != index.Size() {
+		t.Fatal("Expected", index.Size(), "got", bt.Size())
+	}
+}
+
+// Long keys that differ early leave short separators in the internal
+// pages, so the tree stays shallow.
+func TestShortSeparators(t *testing.T) {
+	for _, inOrder := range []bool{false, true} {
+		bt := NewInMemoryBtree().(*Btree)
+		keys := make([][]byte, 3000)
+		for i := range keys {
+			k := make([]byte, 1000)
+			binary.BigEndian.PutUint32(k, uint32(i)*2654435761)
+			keys[i] = k
+		}
+		if inOrder {
+			sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+			for _, k := range keys {
+				bt.PutNext(k, k[:4])
+			}
+		} else {
+			for _, k := range keys {
+				bt.Put(k, k[:4])
+			}
+		}
+		if err := bt.CheckConsistency(); err != nil {
+			t.Fatal(err)
+		}
+
+		// full keys would take two levels of internal pages
+		if bt.height > 2 {
+			t.Fatal("Expected a tree of height 2, got", bt.height)
+		}
+		root := bt.pager.Get(bt.root)
+		for i := 1; i < root.Size(); i++ {
+			if k, _ := root.GetKey(i); len(k) > 4 {
+				t.Fatal("Expected separators of at most 4 bytes, got", len(k))
+			}
+		}
+		for _, k := range keys {
+			if v, ok := bt.Get(k); !ok || !bytes.Equal(v, k[:4]) {
+				t.Fatal("Expected", k[:4], "got", v)
+			}
+		}
+		bt.Dispose()
+	}
+}
+
+func TestSeparator(t *testing.T) {
+	for _, c := range []struct{ left, right, want string }{
+		{"a", "b", "b"},
+		{"abc", "abd", "abd"},
+		{"ab", "abcdef", "abc"},
+		{"abcx", "abdxyz", "abd"},
+		{"a\xff", "b\x00\x00", "b"},
+	} {
+		if got := separator([]byte(c.left), []byte(c.right)); string(got) != c.want {
+			t.Error("Expected", c.want, "between", c.left, "and", c.right, "got", string(got))
+		}
+	}
+}
+
+func TestKeyTooLarge(t *testing.T) {
+	if err := CheckKey(make([]byte, MaxKeySize)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckKey(make([]byte, MaxKeySize+1)); err != ErrKeyTooLarge {
+		t.Fatal("Expected ErrKeyTooLarge, got", err)
+	}
+
+	index := NewInMemoryBtree()
+	defer index.Dispose()
+	defer func() {
+		if r := recover(); r != ErrKeyTooLarge {
+			t.Fatal("Expected to panic with ErrKeyTooLarge, got", r)
+		}
+	}()
+	index.Put(make([]byte, MaxKeySize+1), []byte{1})
+}
+
+func TestAllocators(t *testing.T) {
+	for _, name := range malloc.Names() {
+		alloc, err := malloc.New(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log("Allocator", name)
+
+		index1 := NewInMemoryBtreeOptions(Options{Allocator: alloc})
+		keys := fill(t, index1)
+
+		index2 := NewInMemoryBtreeOptions(Options{Allocator: alloc}).(*Btree)
+		iter := index1.Start([]byte{})
+		for {
+			k, v, ok := iter.Next()
+			if !ok {
+				break
+			}
+			index2.PutNext(k, v)
+		}
+		if err := index2.CheckConsistency(); err != nil {
+			t.Fatal(err)
+		}
+		index1.Dispose()
+
+		for _, k := range keys {
+			v, ok := index2.Get(k)
+			if !ok || !bytes.Equal(k, v) {
+				t.Fatal(name, "expected", k, "got", v, "ok =", ok)
+			}
+		}
+		index2.Dispose()
+	}
+}
+
+func TestDisposeFreesEverything(t *testing.T) {
+	alloc := malloc.NewDebug(malloc.Default)
+	index := NewInMemoryBtreeOptions(Options{Allocator: alloc}).(*Btree)
+	fill(t, index)
+	index.Put([]byte{1, 2, 3, 4, 5}, make([]byte, 3*bufSize))
+
+	// Dispose must not free released pages again.
+	ref, _ := index.pager.New(true)
+	index.pager.Release(ref)
+
+	if count, _ := alloc.Live(); count == 0 {
+		t.Fatal("Expected live allocations")
+	}
+	index.Dispose()
+	if count, bytes := alloc.Live(); count != 0 {
+		alloc.Report(os.Stderr)
+		t.Fatal("Leaked", count, "allocations,", bytes, "bytes")
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	index := NewInMemoryBtree().(*Btree)
+	defer index.Dispose()
+	fill(t, index)
+
+	stats := index.Stats()
+	usage := index.MemoryUsage()
+	if usage.PageBytes != int64(stats.PageBytes) || usage.ValueStoreBytes != int64(stats.ValueStoreBytes) {
+		t.Fatal("Usage", usage, "does not agree with stats", stats)
+	}
+	if usage.ScratchBytes != inMemoryPageSize {
+		t.Fatal("Expected one page of scratch, got", usage.ScratchBytes)
+	}
+
+	ref, _ := index.pager.New(true)
+	if index.MemoryUsage().PageBytes != usage.PageBytes+inMemoryPageSize {
+		t.Fatal("Expected a new page to count")
+	}
+	index.pager.Release(ref)
+	if index.MemoryUsage() != usage {
+		t.Fatal("Expected a released page to not count")
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	const budget = 4 << 20
+	var reached []MemoryUsage
+	index := NewInMemoryBtreeOptions(Options{
+		MemoryBudget: budget,
+		OnBudgetReached: func(usage MemoryUsage) {
+			reached = append(reached, usage)
+		},
+	}).(*Btree)
+	defer index.Dispose()
+
+	value := make([]byte, 1000)
+	count := int32(0)
+	for ; !index.BudgetReached(); count++ {
+		if int(count) > budget/len(value) {
+			t.Fatal("Expected to reach the budget by now:", index.MemoryUsage())
+		}
+		k := &bytes.Buffer{}
+		binary.Write(k, binary.BigEndian, count)
+		index.Put(k.Bytes(), value)
+		if !index.BudgetReached() && len(reached) != 0 {
+			t.Fatal("Callback before the budget was reached")
+		}
+	}
+
+	if len(reached) != 1 || reached[0].Total() < budget {
+		t.Fatal("Expected one callback at the budget, got", reached)
+	}
+	if index.MemoryUsage().Total()-budget > bufSize {
+		t.Fatal("Overshot the budget:", index.MemoryUsage())
+	}
+
+	// does not fire again
+	index.Put([]byte{0xff, 0xff, 0xff, 0xff, 0xff}, value)
+	if len(reached) != 1 {
+		t.Fatal("Expected the callback only once, got", len(reached))
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+	index := NewInMemoryBtree().(*Btree)
+	defer index.Dispose()
+	keys := fill(t, index)
+
+	batch := make([][]byte, 0)
+	for i := 0; i < 5000; i++ {
+		batch = append(batch, keys[rand.Intn(len(keys))])
+		if i%10 == 0 {
+			// not in the tree
+			batch = append(batch, []byte{byte(i), 0xff, 0xff, 0xff, 0xff})
+		}
+	}
+
+	values, found := index.GetBatch(batch)
+	if len(values) != len(batch) || len(found) != len(batch) {
+		t.Fatal("Expected a result per key")
+	}
+	for i, k := range batch {
+		v, ok := index.Get(k)
+		if ok != found[i] || !bytes.Equal(v, values[i]) {
+			t.Fatal("Expected", k, "to give", v, ok, "got", values[i], found[i])
+		}
+	}
+}
+
+func TestPutBatch(t *testing.T) {
+	index1 := NewInMemoryBtree().(*Btree)
+	defer index1.Dispose()
+	index2 := NewInMemoryBtree().(*Btree)
+	defer index2.Dispose()
+
+	for round := 0; round < 10; round++ {
+		keys := make([][]byte, 0)
+		values := make([][]byte, 0)
+		for i := 0; i < 3000; i++ {
+			k := bigEndianKey(rand.Intn(20000))
+			keys = append(keys, k)
+			values = append(values, []byte{byte(round), byte(i)})
+		}
+
+		replaced := index1.PutBatch(keys, values)
+		for i, k := range keys {
+			if r := index2.Put(k, values[i]); r != replaced[i] {
+				t.Fatal("Expected replaced", r, "for", k, "got", replaced[i])
+			}
+		}
+		if err := index1.CheckConsistency(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if index1.Size() != index2.Size() {
+		t.Fatal("Expected", index2.Size(), "got", index1.Size())
+	}
+	iter1 := index1.Start([]byte{})
+	iter2 := index2.Start([]byte{})
+	for {
+		k1, v1, ok1 := iter1.Next()
+		k2, v2, ok2 := iter2.Next()
+		if ok1 != ok2 || !bytes.Equal(k1, k2) || !bytes.Equal(v1, v2) {
+			t.Fatal("Not the same:", ok1, ok2, k1, k2, v1, v2)
+		}
+		if !ok1 {
+			break
+		}
+	}
+}
+
+// Timestamps that mostly ascend, with some arriving late, mixed with
+// batches and appends that move the finger elsewhere.
+func TestMostlyAscendingPut(t *testing.T) {
+	index := NewInMemoryBtree().(*Btree)
+	defer index.Dispose()
+	model := map[string][]byte{}
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200000; i++ {
+		ts := i*10 + r.Intn(50)
+		if r.Intn(1000) == 0 {
+			// very late
+			ts -= r.Intn(100000)
+		}
+		if ts < 0 {
+			ts = 0
+		}
+		k, v := bigEndianKey(ts), []byte{byte(i)}
+		switch r.Intn(500) {
+		case 0:
+			early := bigEndianKey(r.Intn(ts + 1))
+			index.PutBatch([][]byte{early, k}, [][]byte{v, v})
+			model[string(early)] = v
+		case 1:
+			index.Append(k, v)
+			v = append(model[string(k)], v...)
+		default:
+			if replaced := index.Put(k, v); replaced != (model[string(k)] != nil) {
+				t.Fatal("Expected replaced to be", !replaced, "for", k)
+			}
+		}
+		model[string(k)] = v
+	}
+
+	if err := index.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range model {
+		if got, ok := index.Get([]byte(k)); !ok || !bytes.Equal(got, v) {
+			t.Fatal("Expected", v, "for", []byte(k), "got", got, ok)
+		}
+	}
+
+	// the runs fill leaves as PutNext would, where splitting in
+	// half would leave them half full
+	if fill := index.Stats().FillRate; fill < 0.8 {
+		t.Fatal("Expected leaves filled by ascending runs, got a fill rate of", fill)
+	}
+}
+
+func BenchmarkPutAscending(b *testing.B) {
+	index := NewInMemoryBtree()
+	defer index.Dispose()
+	for i := 0; i < b.N; i++ {
+		k := bigEndianKey(i)
+		index.Put(k, k)
+	}
+}
+
+func BenchmarkPutNextAscending(b *testing.B) {
+	index := NewInMemoryBtree().(*Btree)
+	defer index.Dispose()
+	for i := 0; i < b.N; i++ {
+		k := bigEndianKey(i)
+		index.PutNext(k, k)
+	}
+}
+
+func benchmarkGets(b *testing.B, batchSize int) {
+	index := NewInMemoryBtree().(*Btree)
+	defer index.Dispose()
+	const n = 1000000
+	for i := 0; i < n; i++ {
+		index.PutNext(bigEndianKey(i), bigEndianKey(i))
+	}
+	batch := make([][]byte, batchSize)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		for j := range batch {
+			batch[j] = bigEndianKey(rand.Intn(n))
+		}
+		if batchSize == 1 {
+			index.Get(batch[0])
+		} else {
+			index.GetBatch(batch)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	benchmarkGets(b, 1)
+}
+
+func BenchmarkGetBatch(b *testing.B) {
+	benchmarkGets(b, 10000)
+}
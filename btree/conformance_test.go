@@ -0,0 +1,29 @@
+package btree
+
+import (
+	"testing"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/indexestest"
+)
+
+var conformance = indexestest.Options{
+	Check: func(index indexes.ROIndex) error {
+		return index.(*Btree).CheckConsistency()
+	},
+	MaxKeySize: MaxKeySize,
+}
+
+func TestConformance(t *testing.T) {
+	indexestest.Run(t, NewInMemoryBtree, conformance)
+}
+
+func TestConformanceInOrder(t *testing.T) {
+	indexestest.RunInOrder(t, func() indexestest.InOrderIndex {
+		return NewInMemoryBtree().(*Btree)
+	}, conformance)
+}
+
+func FuzzBtree(f *testing.F) {
+	indexestest.Fuzz(f, NewInMemoryBtree, conformance)
+}
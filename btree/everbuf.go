@@ -1,6 +1,8 @@
 package btree
 
 import (
+	"encoding/binary"
+
 	"github.com/avisagie/indexes/malloc"
 )
 
@@ -8,30 +10,53 @@ const (
 	bufSize = 1 << 20
 )
 
+// Append only store for values. Values are kept in bufSize buffers,
+// each prefixed with its length as a uvarint. A value that does not
+// fit in a buffer gets an overflow buffer of its own, which takes up
+// a buffer slot so that references stay buffer*bufSize+offset.
 type everbuf struct {
-	bufs [][]byte
-	cur  []byte
-	curr int
+	alloc malloc.Allocator
+	bufs  [][]byte
+	cur   []byte
+	curi  int
+	curr  int
+
+	// bytes allocated so far
+	size int64
 }
 
-func newEverbuf() *everbuf {
-	return &everbuf{make([][]byte, 0), []byte{}, 0}
+func newEverbuf(alloc malloc.Allocator) *everbuf {
+	return &everbuf{alloc, make([][]byte, 0), []byte{}, 0, 0, 0}
 }
 
 // Copy these bytes, and return a refernce that lets you get it back.
-func (e *everbuf) Put(b []byte) (ref int) {
-	if len(b)+e.curr >= len(e.cur) {
-		e.cur = malloc.Malloc(bufSize)
+func (e *everbuf) Put(b []byte) (ref int64) {
+	var hdr [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(b)))
+	l := n + len(b)
+
+	if l > bufSize {
+		// overflow: give it a buffer of its own
+		buf := e.alloc.Malloc(l)
+		e.size += int64(l)
+		copy(buf, hdr[:n])
+		copy(buf[n:], b)
+		e.bufs = append(e.bufs, buf)
+		return bufSize * int64(len(e.bufs)-1)
+	}
+
+	if l+e.curr > len(e.cur) {
+		e.cur = e.alloc.Malloc(bufSize)
+		e.size += bufSize
 		e.bufs = append(e.bufs, e.cur)
+		e.curi = len(e.bufs) - 1
 		e.curr = 0
 	}
-	l := len(b)
 	o := e.curr
-	e.cur[o] = byte(uint16(l) & uint16(0x0F))
-	e.cur[o+1] = byte(uint16(l>>8) & uint16(0x0F))
-	copy(e.cur[o+2:o+2+l], b)
-	ref = e.curr + bufSize*(len(e.bufs)-1)
-	e.curr += 2 + l
+	copy(e.cur[o:], hdr[:n])
+	copy(e.cur[o+n:o+l], b)
+	ref = int64(o) + bufSize*int64(e.curi)
+	e.curr += l
 	if e.curr&0x07 != 0 {
 		e.curr = (e.curr | 0x07) + 1
 	}
@@ -39,21 +64,29 @@ func (e *everbuf) Put(b []byte) (ref int) {
 }
 
 // Returns a slice into the underlying storage. Take care to not
-// change it or let it escape to someone who might.
-func (e *everbuf) Get(ref int) []byte {
-	p := ref / bufSize
-	o := ref % bufSize
+// change it or let it escape to someone who might. Its capacity is
+// its length, so appending to it copies.
+func (e *everbuf) Get(ref int64) []byte {
+	p := int(ref / bufSize)
+	o := int(ref % bufSize)
 	b := e.bufs[p]
-	l := int(uint16(b[o]) | (uint16(b[o+1]) << 8))
-	return b[o+2 : o+2+l]
+	l, n := binary.Uvarint(b[o:])
+	s := o + n
+	end := s + int(l)
+	return b[s:end:end]
+}
+
+func (e *everbuf) TotalSize() int64 {
+	return e.size
 }
 
-func (e *everbuf) TotalSize() int {
-	return len(e.bufs) * bufSize
+// Bytes at the end of the current buffer that are not used yet.
+func (e *everbuf) Slack() int64 {
+	return int64(len(e.cur) - e.curr)
 }
 
 func (e *everbuf) Dispose() {
 	for _, b := range e.bufs {
-		malloc.Free(b)
+		e.alloc.Free(b)
 	}
 }
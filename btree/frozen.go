@@ -0,0 +1,160 @@
+package btree
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/malloc"
+)
+
+// Keys per entry in the sparse index of a Frozen.
+const frozenBlock = 16
+
+// An immutable copy of a Btree with nothing to spare: the keys back
+// to back in order, the values back to back in the same order, and
+// where each starts. A sparse index holds the first 8 bytes of every
+// frozenBlock-th key as a number, so that most of a search compares
+// integers and stays in a small array. Satisfies indexes.ROIndex.
+type Frozen struct {
+	alloc malloc.Allocator
+	bufs  [][]byte
+
+	keys   []byte
+	values []byte
+	// where key and value i start, and one past the end
+	keyOffsets   []int64
+	valueOffsets []int64
+	sparse       []uint64
+}
+
+// Copy the tree into a Frozen. The tree can be disposed of
+// afterwards, or changed without affecting the copy.
+func (b *Btree) Freeze() *Frozen {
+	n := int(b.Size())
+	keyBytes, valueBytes := 0, 0
+	it := b.Start(nil)
+	for {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		keyBytes += len(k)
+		valueBytes += len(v)
+	}
+
+	f := &Frozen{alloc: b.alloc}
+	f.keys = f.malloc(keyBytes)
+	f.values = f.malloc(valueBytes)
+	f.keyOffsets = getInt64s(f.malloc(8 * (n + 1)))
+	f.valueOffsets = getInt64s(f.malloc(8 * (n + 1)))
+	f.sparse = getUint64s(f.malloc(8 * ((n + frozenBlock - 1) / frozenBlock)))
+
+	ko, vo := 0, 0
+	it = b.Start(nil)
+	for i := 0; i < n; i++ {
+		k, v, _ := it.Next()
+		f.keyOffsets[i], f.valueOffsets[i] = int64(ko), int64(vo)
+		ko += copy(f.keys[ko:], k)
+		vo += copy(f.values[vo:], v)
+		if i%frozenBlock == 0 {
+			f.sparse[i/frozenBlock] = keyBits(k, 0)
+		}
+	}
+	f.keyOffsets[n], f.valueOffsets[n] = int64(ko), int64(vo)
+	return f
+}
+
+// Allocates at least a byte, so that there is something to point at.
+func (f *Frozen) malloc(size int) []byte {
+	n := size
+	if n == 0 {
+		n = 1
+	}
+	buf := f.alloc.Malloc(n)
+	f.bufs = append(f.bufs, buf)
+	return buf[:size:size]
+}
+
+func (f *Frozen) key(i int) []byte {
+	return f.keys[f.keyOffsets[i]:f.keyOffsets[i+1]]
+}
+
+func (f *Frozen) value(i int) []byte {
+	s, e := f.valueOffsets[i], f.valueOffsets[i+1]
+	return f.values[s:e:e]
+}
+
+// Position of the first key >= key.
+func (f *Frozen) find(key []byte) int {
+	n := f.Size()
+	bits := keyBits(key, 0)
+	// The keys in blocks whose first key has smaller bits are all
+	// smaller than key, up to the last such block, and the keys from
+	// a block whose first key has larger bits on are all larger.
+	lo := sort.Search(len(f.sparse), func(j int) bool { return f.sparse[j] >= bits })
+	hi := lo + sort.Search(len(f.sparse)-lo, func(j int) bool { return f.sparse[lo+j] > bits })
+	start := 0
+	if lo > 0 {
+		start = (lo - 1) * frozenBlock
+	}
+	end := int(n)
+	if hi*frozenBlock < end {
+		end = hi * frozenBlock
+	}
+	return start + sort.Search(end-start, func(i int) bool { return bytes.Compare(f.key(start+i), key) >= 0 })
+}
+
+func (f *Frozen) Get(key []byte) (value []byte, ok bool) {
+	i := f.find(key)
+	if i < int(f.Size()) && bytes.Equal(f.key(i), key) {
+		return f.value(i), true
+	}
+	return nil, false
+}
+
+type frozenIter struct {
+	f      *Frozen
+	prefix []byte
+	i      int
+	done   bool
+}
+
+func (f *Frozen) Start(prefix []byte) indexes.Iter {
+	return &frozenIter{f, copyBytes(prefix), f.find(prefix), false}
+}
+
+func (it *frozenIter) Next() (key []byte, value []byte, ok bool) {
+	if it.done || it.i >= int(it.f.Size()) || !prefixMatches(it.f.key(it.i), it.prefix) {
+		it.done = true
+		return
+	}
+	key, value = it.f.key(it.i), it.f.value(it.i)
+	it.i++
+	return key, value, true
+}
+
+func (f *Frozen) Size() int64 {
+	return int64(len(f.keyOffsets) - 1)
+}
+
+// Number of keys smaller than key.
+func (f *Frozen) Rank(key []byte) int64 {
+	return int64(f.find(key))
+}
+
+// Bytes allocated for the keys, values and indexes.
+func (f *Frozen) Bytes() int64 {
+	total := int64(0)
+	for _, b := range f.bufs {
+		total += int64(len(b))
+	}
+	return total
+}
+
+func (f *Frozen) Dispose() {
+	for _, b := range f.bufs {
+		f.alloc.Free(b)
+	}
+	f.bufs = nil
+}
@@ -0,0 +1,71 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/indexestest"
+)
+
+func TestFrozenConformance(t *testing.T) {
+	indexestest.RunRO(t, func(kvs []indexestest.KV) indexes.ROIndex {
+		index := NewInMemoryBtree().(*Btree)
+		defer index.Dispose()
+		for _, kv := range kvs {
+			index.Put(kv.Key, kv.Value)
+		}
+		return index.Freeze()
+	}, indexestest.Options{})
+}
+
+func TestFreeze(t *testing.T) {
+	index := NewInMemoryBtree().(*Btree)
+	defer index.Dispose()
+	keys := fill(t, index)
+
+	frozen := index.Freeze()
+	defer frozen.Dispose()
+
+	// the frozen copy does not change with the tree
+	index.Put([]byte{0xff, 0xff, 0xff, 0xff, 0xff}, []byte{1})
+	if frozen.Size() != int64(len(keys)) {
+		t.Fatal("Expected", len(keys), "keys, got", frozen.Size())
+	}
+	for _, k := range keys {
+		if rank := frozen.Rank(k); rank != index.Rank(k) {
+			t.Fatal("Expected rank", index.Rank(k), "got", rank)
+		}
+	}
+
+	usage := index.MemoryUsage()
+	if frozen.Bytes() >= usage.PageBytes+usage.ValueStoreBytes {
+		t.Error("Expected the frozen copy to be smaller:", frozen.Bytes(), "bytes, the tree", usage.PageBytes+usage.ValueStoreBytes)
+	}
+	t.Log("Frozen:", frozen.Bytes(), "bytes, tree:", usage.Total(), "bytes, fill rate", index.Stats().FillRate)
+}
+
+func benchmarkFrozenGet(b *testing.B, freeze bool) {
+	index := NewInMemoryBtree().(*Btree)
+	defer index.Dispose()
+	keys := make([][]byte, 1<<18)
+	for i, j := range rand.Perm(len(keys)) {
+		keys[i] = bigEndianKey(j * 7919)
+		index.Put(keys[i], keys[i])
+	}
+
+	var ro indexes.ROIndex = index
+	if freeze {
+		frozen := index.Freeze()
+		defer frozen.Dispose()
+		ro = frozen
+	}
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ro.Get(keys[r.Intn(len(keys))])
+	}
+}
+
+func BenchmarkFrozenGet(b *testing.B)    { benchmarkFrozenGet(b, true) }
+func BenchmarkNotFrozenGet(b *testing.B) { benchmarkFrozenGet(b, false) }
@@ -5,11 +5,13 @@ type Key interface {
 	Get() []byte
 	// In leaf nodes: reference to values. In internal nodes:
 	// reference to the page with keys equal to or greater.
-	Ref() int
+	Ref() int64
+	// Position of the key in its page, -1 if there is none.
+	Pos() int
 }
 
 type PageIter interface {
-	Next() (key []byte, ref int, ok bool)
+	Next() (key []byte, ref int64, ok bool)
 }
 
 type Page interface {
@@ -20,12 +22,12 @@ type Page interface {
 	// inserting the key is that the page is full. The pager
 	// promises to not be dependent on your copy of the byte slice
 	// after this operation returns.
-	Insert(k []byte, ref int) (ok bool)
+	Insert(k []byte, ref int64) (ok bool)
 
 	// Like insert, but you promise that you are inserting in
 	// order. This is a special case of Insert. You can always
 	// just call Insert inside PutNext.
-	PutNext(k []byte, ref int) (ok bool)
+	PutNext(k []byte, ref int64) (ok bool)
 
 	// Returns true and the key if it is found. Returns false and
 	// one key smaller if not found so that btree can use its
@@ -35,8 +37,8 @@ type Page interface {
 	IsLeaf() bool
 
 	// Return the next page at this level
-	NextPage() (ref int)
-	SetNextPage(ref int)
+	NextPage() (ref int64)
+	SetNextPage(ref int64)
 
 	// Iterator support. This iterator will stop at the end of the
 	// page. It is the responsibility of the btree implementation
@@ -46,13 +48,20 @@ type Page interface {
 	// Get the key and ref at this index. For leaves keys start at
 	// 1. for internal nodes, key number 0 contains the left
 	// reference, as set by SetFirst, and no actual key.
-	GetKey(i int) ([]byte, int)
+	GetKey(i int) ([]byte, int64)
 
-	// Split this page into the given one
-	Split(newPageRef int, newPage Page) (splitKey []byte)
+	// Split this page into the given one, to make room for key,
+	// where policy says. key is not inserted, but the split leaves
+	// room for it on whichever side it belongs.
+	Split(newPageRef int64, newPage Page, key []byte, policy SplitPolicy) (splitKey []byte)
 
-	First() int
-	SetFirst(ref int)
+	First() int64
+	SetFirst(ref int64)
+
+	// In internal nodes: the number of keys in the subtree that
+	// key i refers to. The btree keeps them up to date.
+	Count(i int) int64
+	SetCount(i int, count int64)
 
 	// Number of keys. See GetKey for an explanation of what to
 	// expect around key 0.
@@ -60,14 +69,15 @@ type Page interface {
 
 	// Tie allocation of space for values to the pages. Only
 	// relevant for leaf nodes.
-	InsertValue(value []byte) int
-	GetValue(ref int) []byte
+	InsertValue(value []byte) int64
+	GetValue(ref int64) []byte
 }
 
 type Pager interface {
-	New(isLeaf bool) (ref int, page Page)
-	Get(ref int) (page Page)
-	Release(ref int)
+	New(isLeaf bool) (ref int64, page Page)
+	Get(ref int64) (page Page)
+	Release(ref int64)
 	Stats() BtreeStats
+	MemoryUsage() MemoryUsage
 	Dispose()
 }
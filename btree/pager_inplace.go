@@ -12,6 +12,11 @@ const (
 	// This is a good inMemoryPageSize for x64 while building an in-memory
 	// b+tree with small keys.
 	inMemoryPageSize = 16 << 10
+
+	// The largest key that can go into a page. A page has to take
+	// at least three of them so that a split always leaves space
+	// for the key that caused it.
+	MaxKeySize = inMemoryPageSize/4 - pageEntrySize
 )
 
 type inplacePageIter struct {
@@ -20,7 +25,7 @@ type inplacePageIter struct {
 	p      *inplacePage
 }
 
-func (i *inplacePageIter) Next() (key []byte, ref int, ok bool) {
+func (i *inplacePageIter) Next() (key []byte, ref int64, ok bool) {
 	if i.pos >= i.p.numPageEntries {
 		return
 	}
@@ -36,22 +41,23 @@ func (i *inplacePageIter) Next() (key []byte, ref int, ok bool) {
 
 type keyRef struct {
 	key []byte
-	ref int
+	ref int64
+	pos int
 }
 
 func (k keyRef) Get() []byte {
 	return k.key
 }
 
-func (k keyRef) Ref() int {
+func (k keyRef) Ref() int64 {
 	return k.ref
 }
 
-func newKeyRef(key []byte, ref int) keyRef {
-	return keyRef{key, ref}
+func (k keyRef) Pos() int {
+	return k.pos
 }
 
-var nilKeyRef = keyRef{nil, -1}
+var nilKeyRef = keyRef{nil, -1, -1}
 
 // Implements Page using byte slices on the heap. Keys store length,
 // bytes and a reference to the value in the page itself. If it is a
@@ -74,7 +80,7 @@ type inplacePage struct {
 	bottom int
 
 	// Reference to the next page
-	next   int32
+	next   int64
 	isLeaf bool
 	r      *inplacePager
 
@@ -83,7 +89,7 @@ type inplacePage struct {
 
 func newInplacePage(isLeaf bool, r *inplacePager) *inplacePage {
 	ret := &inplacePage{
-		data:           malloc.Malloc(inMemoryPageSize),
+		data:           r.alloc.Malloc(inMemoryPageSize),
 		numPageEntries: 0,
 		bottom:         inMemoryPageSize,
 		next:           -1,
@@ -100,24 +106,48 @@ func newInplacePage(isLeaf bool, r *inplacePager) *inplacePage {
 	return ret
 }
 
+// Compare the prefixes in the page entries before looking at the
+// keys themselves in find. Turned off only to benchmark without it.
+var findWithPrefixes = true
+
 func (p *inplacePage) find(key []byte) (pos int) {
-	pos = sort.Search(p.numPageEntries, func(i int) bool {
+	p.finds++
+	if !findWithPrefixes {
+		return sort.Search(p.numPageEntries, func(i int) bool {
+			p.comparisons++
+			k, _ := p.readKey(i)
+			return !keyLess(k, key)
+		})
+	}
+
+	// Keys with different prefixes compare as their prefixes do, so
+	// only ties need the key bytes.
+	prefix := keyPrefix(key)
+	entries := p.pageEntries[:p.numPageEntries]
+	return sort.Search(len(entries), func(i int) bool {
 		p.comparisons++
-		k, _ := p.readKey(i)
-		return !keyLess(k, key)
+		e := &entries[i]
+		if e.prefix != prefix {
+			return e.prefix > prefix
+		}
+		offset := int(e.offset)
+		return !keyLess(p.data[offset:offset+int(e.length)], key)
 	})
-	p.finds++
-	return
 }
 
-func (p *inplacePage) readKey(pos int) (key []byte, ref int) {
+func (p *inplacePage) readKey(pos int) (key []byte, ref int64) {
 	e := p.pageEntries[pos]
 	offset := int(e.offset)
 	length := int(e.length)
-	return p.data[offset : offset+length], int(e.ref)
+	return p.data[offset : offset+length], e.ref
 }
 
-func (p *inplacePage) writeKey(pos int, key []byte, ref int32) bool {
+func (p *inplacePage) keyRefAt(pos int) keyRef {
+	key, ref := p.readKey(pos)
+	return keyRef{key, ref, pos}
+}
+
+func (p *inplacePage) writeKey(pos int, key []byte, ref int64) bool {
 	if p.bottom-len(key) < pageEntrySize*(p.numPageEntries+1) {
 		// PLIF
 		return false
@@ -131,6 +161,7 @@ func (p *inplacePage) writeKey(pos int, key []byte, ref int32) bool {
 		offset: uint16(offset),
 		length: uint16(len(key)),
 		ref:    ref,
+		prefix: keyPrefix(key),
 	}
 
 	// insert the entry offset into the right place maintain
@@ -142,7 +173,7 @@ func (p *inplacePage) writeKey(pos int, key []byte, ref int32) bool {
 	return true
 }
 
-func (p *inplacePage) Insert(key []byte, ref int) bool {
+func (p *inplacePage) Insert(key []byte, ref int64) bool {
 	pos := p.find(key)
 
 	const refSize = 4
@@ -151,7 +182,7 @@ func (p *inplacePage) Insert(key []byte, ref int) bool {
 		// replace
 		if bytes.Equal(key, k) {
 			// add the reference after the existing one
-			p.pageEntries[pos].ref = int32(ref)
+			p.pageEntries[pos].ref = ref
 			return true
 		}
 
@@ -161,11 +192,11 @@ func (p *inplacePage) Insert(key []byte, ref int) bool {
 		}
 	}
 
-	return p.writeKey(pos, key, int32(ref))
+	return p.writeKey(pos, key, ref)
 }
 
-func (p *inplacePage) PutNext(key []byte, ref int) bool {
-	return p.appendKey(key, int32(ref))
+func (p *inplacePage) PutNext(key []byte, ref int64) bool {
+	return p.appendKey(key, ref)
 }
 
 func (p *inplacePage) Search(key []byte) (k Key, ok bool) {
@@ -173,15 +204,15 @@ func (p *inplacePage) Search(key []byte) (k Key, ok bool) {
 	if pos == p.numPageEntries {
 		// key is greater than the last key in this page.
 		if !p.isLeaf {
-			return newKeyRef(p.readKey(pos - 1)), false
+			return p.keyRefAt(pos - 1), false
 		}
 		return nilKeyRef, false
 	}
 
-	k = newKeyRef(p.readKey(pos))
+	k = p.keyRefAt(pos)
 	ok = bytes.Equal(key, k.Get())
 	if !ok && !p.isLeaf && keyLess(key, k.Get()) {
-		k = newKeyRef(p.readKey(pos - 1))
+		k = p.keyRefAt(pos - 1)
 	}
 	return
 }
@@ -190,29 +221,102 @@ func (p *inplacePage) IsLeaf() bool {
 	return p.isLeaf
 }
 
-func (p *inplacePage) NextPage() (ref int) {
-	return int(p.next)
+func (p *inplacePage) NextPage() (ref int64) {
+	return p.next
 }
 
-func (p *inplacePage) SetNextPage(ref int) {
-	p.next = int32(ref)
+func (p *inplacePage) SetNextPage(ref int64) {
+	p.next = ref
 }
 
 func (p *inplacePage) Start(prefix []byte) PageIter {
 	return &inplacePageIter{p.find(prefix), prefix, p}
 }
 
-func (p *inplacePage) GetKey(i int) ([]byte, int) {
+func (p *inplacePage) GetKey(i int) ([]byte, int64) {
 	return p.readKey(i)
 }
 
 // Used in split. Does not need to do binary search, just keep adding
 // to the end.
-func (p *inplacePage) appendKey(key []byte, ref int32) bool {
+func (p *inplacePage) appendKey(key []byte, ref int64) bool {
 	return p.writeKey(p.numPageEntries, key, ref)
 }
 
-func (p *inplacePage) Split(newPageRef int, newPage1 Page) (splitKey []byte) {
+// Like appendKey, but keeps the entry's count too.
+func (p *inplacePage) appendEntry(key []byte, entry pageEntry) bool {
+	if !p.appendKey(key, entry.ref) {
+		return false
+	}
+	p.pageEntries[p.numPageEntries-1].count = entry.count
+	return true
+}
+
+// Find the entry to split at: where policy says,
+// unless that leaves no space for key on the side it goes to, which
+// can happen when key sizes vary. In a leaf, a key that goes where
+// the pages part could end up on either side.
+func (p *inplacePage) splitPoint(entries []pageEntry, key []byte, policy SplitPolicy) int {
+	n := len(entries)
+	insert := sort.Search(n, func(i int) bool {
+		e := entries[i]
+		offset := int(e.offset)
+		return !keyLess(p.r.scratchData[offset:offset+int(e.length)], key)
+	})
+	keySize := pageEntrySize + len(key)
+
+	// sums[i] is the bytes taken by entries [0, i)
+	sizes := make([]int, n)
+	sums := make([]int, n+1)
+	for i, e := range entries {
+		sizes[i] = pageEntrySize + int(e.length)
+		sums[i+1] = sums[i] + sizes[i]
+	}
+	leftFits := func(pos int) bool {
+		left := sums[pos]
+		if insert <= pos {
+			left += keySize
+		}
+		return left <= inMemoryPageSize
+	}
+	rightFits := func(pos int) bool {
+		var right int
+		if p.isLeaf {
+			right = sums[n] - sums[pos]
+			if insert >= pos {
+				right += keySize
+			}
+		} else {
+			// the middle key moves up and the new page gets a
+			// first entry
+			right = pageEntrySize + sums[n] - sums[pos+1]
+			if insert > pos {
+				right += keySize
+			}
+		}
+		return right <= inMemoryPageSize
+	}
+
+	pos := policy(sizes, insert)
+	if pos < 1 {
+		pos = 1
+	}
+	if pos > n-1 {
+		pos = n - 1
+	}
+	for pos > 1 && !leftFits(pos) {
+		pos--
+	}
+	for pos < n-1 && !rightFits(pos) {
+		pos++
+	}
+	if pos < 1 || !leftFits(pos) || !rightFits(pos) {
+		panic(fmt.Sprint("no split point leaves space in page of ", n, " keys"))
+	}
+	return pos
+}
+
+func (p *inplacePage) Split(newPageRef int64, newPage1 Page, key []byte, policy SplitPolicy) (splitKey []byte) {
 	newPage, ok := newPage1.(*inplacePage)
 	if !ok {
 		panic("Cannot split into a different type of page: expected a inplacePage")
@@ -221,6 +325,7 @@ func (p *inplacePage) Split(newPageRef int, newPage1 Page) (splitKey []byte) {
 	copy(p.r.scratchData, p.data)
 	numPageEntries := p.numPageEntries
 	pageEntries := getPageEntries(p.r.scratchData)
+	middle := p.splitPoint(pageEntries[:numPageEntries], key, policy)
 
 	// reset p. If it is not a leaf it will get its first
 	// reference back from scratchData shortly.
@@ -230,11 +335,11 @@ func (p *inplacePage) Split(newPageRef int, newPage1 Page) (splitKey []byte) {
 	pos := 0
 
 	// copy half the keys back into page
-	for ; pos < numPageEntries/2; pos++ {
+	for ; pos < middle; pos++ {
 		entry := pageEntries[pos]
 		offset, length := int(entry.offset), int(entry.length)
 		// fmt.Println("Copying", pos, ":", p.r.scratchData[offset:offset+length], "to left page")
-		if !p.appendKey(p.r.scratchData[offset:offset+length], entry.ref) {
+		if !p.appendEntry(p.r.scratchData[offset:offset+length], entry) {
 			panic("There had to be space")
 		}
 	}
@@ -247,7 +352,8 @@ func (p *inplacePage) Split(newPageRef int, newPage1 Page) (splitKey []byte) {
 		// fmt.Println("SplitKey is", pos, ":", p.r.scratchData[offset:offset+length])
 		if !p.isLeaf {
 			// skip the middle key
-			newPage.SetFirst(int(entry.ref))
+			newPage.SetFirst(entry.ref)
+			newPage.SetCount(0, entry.count)
 			pos++
 		}
 	}
@@ -257,7 +363,7 @@ func (p *inplacePage) Split(newPageRef int, newPage1 Page) (splitKey []byte) {
 		entry := pageEntries[pos]
 		offset, length := int(entry.offset), int(entry.length)
 		//fmt.Println("Copying", pos, ":", p.r.scratchData[offset:offset+length], "to right page")
-		if !newPage.appendKey(p.r.scratchData[offset:offset+length], entry.ref) {
+		if !newPage.appendEntry(p.r.scratchData[offset:offset+length], entry) {
 			panic("There had to be space")
 		}
 	}
@@ -313,51 +419,67 @@ func (p *inplacePage) Split(newPageRef int, newPage1 Page) (splitKey []byte) {
 	*/
 }
 
-func (p *inplacePage) First() int {
-	return int(p.pageEntries[0].ref)
+func (p *inplacePage) First() int64 {
+	return p.pageEntries[0].ref
 }
 
-func (p *inplacePage) SetFirst(ref int) {
+func (p *inplacePage) SetFirst(ref int64) {
 	if p.isLeaf {
 		panic("Not setting first on non-leaf node")
 	}
-	p.pageEntries[0].ref = int32(ref)
+	p.pageEntries[0].ref = ref
+}
+
+func (p *inplacePage) Count(i int) int64 {
+	return p.pageEntries[i].count
+}
+
+func (p *inplacePage) SetCount(i int, count int64) {
+	if p.isLeaf {
+		panic("Leaf nodes do not keep counts")
+	}
+	p.pageEntries[i].count = count
 }
 
 func (p *inplacePage) Size() int {
 	return p.numPageEntries
 }
 
-func (p *inplacePage) InsertValue(value []byte) int {
+func (p *inplacePage) InsertValue(value []byte) int64 {
 	return p.r.values.Put(value)
 }
 
-func (p *inplacePage) GetValue(vref int) []byte {
+func (p *inplacePage) GetValue(vref int64) []byte {
 	return p.r.values.Get(vref)
 }
 
 func (p *inplacePage) Dispose() {
-	malloc.Free(p.data)
+	p.r.alloc.Free(p.data)
 }
 
 // Implements Pager by keeping pages in RAM on the heap.
 type inplacePager struct {
 	pages          []*inplacePage
-	freePages      []int
+	freePages      []int64
 	scratchData    []byte
 	scratchOffsets []int
 	values         *everbuf
+	alloc          malloc.Allocator
+
+	// bytes in pages that are in use
+	pageBytes int64
 }
 
-func newInplacePager() *inplacePager {
-	return &inplacePager{nil, nil, malloc.Malloc(inMemoryPageSize), make([]int, 32), newEverbuf()}
+func newInplacePager(alloc malloc.Allocator) *inplacePager {
+	return &inplacePager{nil, nil, alloc.Malloc(inMemoryPageSize), make([]int, 32), newEverbuf(alloc), alloc, 0}
 }
 
-func (r *inplacePager) New(isLeaf bool) (ref int, page Page) {
+func (r *inplacePager) New(isLeaf bool) (ref int64, page Page) {
 	// This always allocates a new page, i.e. it does not reuse
 	// pages. It forgets them so that GC can get them. It only
 	// reuses refs.
 
+	r.pageBytes += inMemoryPageSize
 	if len(r.freePages) > 0 {
 		ref := r.freePages[len(r.freePages)-1]
 		r.freePages = r.freePages[:len(r.freePages)-1]
@@ -369,13 +491,13 @@ func (r *inplacePager) New(isLeaf bool) (ref int, page Page) {
 		return ref, page
 	}
 
-	ref = len(r.pages)
+	ref = int64(len(r.pages))
 	r.pages = append(r.pages, newInplacePage(isLeaf, r))
 	page = r.pages[ref]
 	return ref, page
 }
 
-func (r *inplacePager) Get(ref int) (page Page) {
+func (r *inplacePager) Get(ref int64) (page Page) {
 	page = r.pages[ref]
 	if page == nil {
 		panic(fmt.Sprint("Trying to get freed page", ref))
@@ -383,10 +505,20 @@ func (r *inplacePager) Get(ref int) (page Page) {
 	return page
 }
 
-func (r *inplacePager) Release(ref int) {
+func (r *inplacePager) Release(ref int64) {
 	r.freePages = append(r.freePages, ref)
 	r.pages[ref].Dispose()
 	r.pages[ref] = nil
+	r.pageBytes -= inMemoryPageSize
+}
+
+func (r *inplacePager) MemoryUsage() MemoryUsage {
+	return MemoryUsage{
+		PageBytes:       r.pageBytes,
+		ValueStoreBytes: r.values.TotalSize(),
+		ScratchBytes:    int64(len(r.scratchData)),
+		ValueStoreSlack: r.values.Slack(),
+	}
 }
 
 func (r *inplacePager) Stats() BtreeStats {
@@ -411,21 +543,26 @@ func (r *inplacePager) Stats() BtreeStats {
 			for ik := 0; ik < p.Size(); ik++ {
 				k, ref := p.GetKey(ik)
 				ret.KeyBytes += len(k)
-				ret.ValueBytes += len(r.values.Get(ref))
+				if p.IsLeaf() {
+					ret.ValueBytes += len(r.values.Get(ref))
+				}
 			}
 
 			ret.PageBytes += inMemoryPageSize
 		}
 	}
 	ret.FillRate = sumFill / countFill
-	ret.ValueStoreBytes = r.values.TotalSize()
+	ret.ValueStoreBytes = int(r.values.TotalSize())
 	return ret
 }
 
 func (r *inplacePager) Dispose() {
 	for _, p := range r.pages {
-		p.Dispose()
+		// released pages are already freed
+		if p != nil {
+			p.Dispose()
+		}
 	}
 	r.values.Dispose()
-	malloc.Free(r.scratchData)
+	r.alloc.Free(r.scratchData)
 }
@@ -3,6 +3,8 @@ package btree
 import (
 	"bytes"
 	"testing"
+
+	"github.com/avisagie/indexes/malloc"
 )
 
 func TestInplacePageReadWrite(t *testing.T) {
@@ -21,8 +23,10 @@ func TestInplacePageReadWrite(t *testing.T) {
 }
 
 func TestInplacePageFind(t *testing.T) {
-	p := newInplacePager()
+	p := newInplacePager(malloc.Default)
+	defer p.Dispose()
 	h := newInplacePage(true, p)
+	defer h.Dispose()
 	h.Insert([]byte{1, 0}, 1)
 	h.Insert([]byte{2, 0}, 3)
 	h.Insert([]byte{0, 0}, 0)
@@ -61,8 +65,10 @@ func TestInplacePageFind(t *testing.T) {
 }
 
 func TestInplacePageSearchEmpty(t *testing.T) {
-	p := newInplacePager()
+	p := newInplacePager(malloc.Default)
+	defer p.Dispose()
 	h := newInplacePage(false, p)
+	defer h.Dispose()
 
 	k, ok := h.Search([]byte{0, 0, 0, 0, 0, 0, 0, 2})
 	t.Log(ok, k)
@@ -72,15 +78,17 @@ func TestInplacePageSearchEmpty(t *testing.T) {
 }
 
 func TestInplacePageSearch(t *testing.T) {
-	p := newInplacePager()
+	p := newInplacePager(malloc.Default)
+	defer p.Dispose()
 	h := newInplacePage(false, p)
+	defer h.Dispose()
 	x := []keyRef{
-		{[]byte{0, 0, 0, 0, 0, 0, 0, 2}, 2},
-		{[]byte{0, 0, 0, 0, 0, 0, 0, 0}, 0},
-		{[]byte{0, 0, 0, 0, 0, 0, 0, 1}, 1},
-		{[]byte{0, 0, 0, 0, 0, 0, 0, 3}, 3},
-		{[]byte{0, 0, 0, 0, 0, 0, 0, 4}, 4},
-		{[]byte{0, 0, 0, 0, 0, 0, 0, 5}, 5},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 2}, 2, 3},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 0}, 0, 1},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 1}, 1, 2},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 3}, 3, 4},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 4}, 4, 5},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 5}, 5, 6},
 	}
 
 	for _, k := range x {
@@ -96,7 +104,7 @@ func TestInplacePageSearch(t *testing.T) {
 
 	k, ok := h.Search([]byte{0, 0, 0, 0, 0, 0, 0, 2})
 	t.Log(ok, k)
-	if !ok || bytes.Compare(k.Get(), []byte{0, 0, 0, 0, 0, 0, 0, 2}) != 0 || k.Ref() != 2 {
+	if !ok || bytes.Compare(k.Get(), []byte{0, 0, 0, 0, 0, 0, 0, 2}) != 0 || k.Ref() != 2 || k.Pos() != 3 {
 		t.Fatal(ok, k)
 	}
 
@@ -114,8 +122,10 @@ func TestInplacePageSearch(t *testing.T) {
 }
 
 func TestInplacePageInsert(t *testing.T) {
-	p := newInplacePager()
+	p := newInplacePager(malloc.Default)
+	defer p.Dispose()
 	h := newInplacePage(true, p)
+	defer h.Dispose()
 
 	if h.Size() != 0 {
 		t.Fatal(h)
@@ -153,3 +163,125 @@ func TestInplacePageInsert(t *testing.T) {
 
 	t.Log(h)
 }
+
+func TestEverbufSizes(t *testing.T) {
+	e := newEverbuf(malloc.Default)
+	defer e.Dispose()
+
+	values := make([][]byte, 0)
+	refs := make([]int64, 0)
+	for _, size := range []int{0, 1, 127, 128, 4095, 4096, 70000, bufSize, 2*bufSize + 3, 5} {
+		v := make([]byte, size)
+		for i := range v {
+			v[i] = byte(i * 31)
+		}
+		values = append(values, v)
+		refs = append(refs, e.Put(v))
+	}
+
+	for i, ref := range refs {
+		got := e.Get(ref)
+		if !bytes.Equal(got, values[i]) {
+			t.Fatal("Expected", len(values[i]), "bytes, got", len(got))
+		}
+		if cap(got) != len(got) {
+			t.Fatal("Expected cap to be len so that appending copies")
+		}
+	}
+}
+
+func TestRefsPast32Bits(t *testing.T) {
+	// Pretend 4GB of values went into the store before these.
+	p := newInplacePager(malloc.Default)
+	defer p.Dispose()
+	p.values.bufs = make([][]byte, (1<<32)/bufSize)
+
+	v := []byte{1, 2, 3}
+	vref := p.values.Put(v)
+	if vref < 1<<32 {
+		t.Fatal("Expected a reference past 32 bits, got", vref)
+	}
+	if !bytes.Equal(p.values.Get(vref), v) {
+		t.Fatal("Got", p.values.Get(vref))
+	}
+
+	h := newInplacePage(false, p)
+	refs := []int64{1 << 31, 1 << 32, 1<<40 + 3, 1<<62 + 1}
+	for i, ref := range refs {
+		if !h.Insert([]byte{byte(i + 1)}, ref) {
+			t.Fatal("Could not insert")
+		}
+	}
+	for i, ref := range refs {
+		k, ok := h.Search([]byte{byte(i + 1)})
+		if !ok || k.Ref() != ref {
+			t.Fatal("Expected", ref, "got", k.Ref())
+		}
+	}
+
+	h.SetNextPage(1<<33 + 7)
+	if h.NextPage() != 1<<33+7 {
+		t.Fatal("Expected", 1<<33+7, "got", h.NextPage())
+	}
+	h.Dispose()
+}
+
+func TestBtreeValuesPast32Bits(t *testing.T) {
+	index := NewInMemoryBtree().(*Btree)
+	defer index.Dispose()
+	index.Put([]byte{1}, []byte{1})
+	values := index.pager.(*inplacePager).values
+	values.bufs = append(values.bufs, make([][]byte, (1<<32)/bufSize)...)
+	values.cur = nil
+
+	for i := 2; i < 1000; i++ {
+		k := []byte{byte(i >> 8), byte(i)}
+		index.Put(k, k)
+	}
+	for i := 2; i < 1000; i++ {
+		k := []byte{byte(i >> 8), byte(i)}
+		v, ok := index.Get(k)
+		if !ok || !bytes.Equal(k, v) {
+			t.Fatal("Expected", k, "got", v)
+		}
+	}
+	if v, ok := index.Get([]byte{1}); !ok || !bytes.Equal(v, []byte{1}) {
+		t.Fatal("Lost the value before the gap:", v)
+	}
+	if k, _, _, _ := index.search([]byte{3, 0}); k.Ref() < 1<<32 {
+		t.Fatal("Expected a reference past 32 bits, got", k.Ref())
+	}
+	if err := index.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindWithPrefixes(t *testing.T) {
+	if pageEntrySize != 24 {
+		t.Fatal("Expected the prefix to fit in the padding of a 24 byte entry, got", pageEntrySize)
+	}
+
+	p := newInplacePager(malloc.Default)
+	defer p.Dispose()
+	h := newInplacePage(true, p)
+	defer h.Dispose()
+
+	// keys that tie on their prefixes, some because of the zero
+	// padding
+	keys := [][]byte{{}, {0}, {0, 0}, {0, 0, 0, 0}, {0, 0, 0, 0, 0}, {1}, {1, 0}, {1, 2, 3, 4}, {1, 2, 3, 4, 0}, {1, 2, 3, 4, 5}, {1, 2, 3, 5}, {0xff, 0xff, 0xff, 0xff, 1}}
+	for i, k := range keys {
+		if !h.Insert(k, int64(i)) {
+			t.Fatal("Could not insert")
+		}
+	}
+	probes := append(keys, []byte{0, 1}, []byte{1, 1}, []byte{1, 2, 3, 4, 4}, []byte{2}, []byte{0xff, 0xff, 0xff, 0xff})
+	for _, k := range probes {
+		pos := h.find(k)
+		findWithPrefixes = false
+		want := h.find(k)
+		findWithPrefixes = true
+		if pos != want {
+			t.Fatal("Expected", k, "at", want, "got", pos)
+		}
+	}
+}
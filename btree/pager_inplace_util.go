@@ -1,16 +1,31 @@
 package btree
 
 import (
+	"encoding/binary"
 	"reflect"
 	"unsafe"
 )
 
 type pageEntry struct {
+	ref int64
+	// In internal nodes, the number of keys under ref.
+	count          int64
 	offset, length uint16
-	ref            int32
+	// The first 4 bytes of the key, big endian and padded with
+	// zeros. It fits in what would otherwise be padding.
+	prefix uint32
 }
 
-var pageEntrySize = int(unsafe.Sizeof(pageEntry{}))
+const pageEntrySize = int(unsafe.Sizeof(pageEntry{}))
+
+func keyPrefix(key []byte) uint32 {
+	if len(key) >= 4 {
+		return binary.BigEndian.Uint32(key)
+	}
+	var buf [4]byte
+	copy(buf[:], key)
+	return binary.BigEndian.Uint32(buf[:])
+}
 
 func getPageEntries(bytes []byte) (ret []pageEntry) {
 	h := (*reflect.SliceHeader)(unsafe.Pointer(&ret))
@@ -27,3 +42,25 @@ func entryOffset(entry int) int {
 func entryEnd(entry int) int {
 	return pageEntrySize * (entry + 1)
 }
+
+func getInt64s(bytes []byte) (ret []int64) {
+	if len(bytes) == 0 {
+		return
+	}
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&ret))
+	h.Data = uintptr(unsafe.Pointer(&bytes[0]))
+	h.Cap = len(bytes) / 8
+	h.Len = h.Cap
+	return
+}
+
+func getUint64s(bytes []byte) (ret []uint64) {
+	if len(bytes) == 0 {
+		return
+	}
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&ret))
+	h.Data = uintptr(unsafe.Pointer(&bytes[0]))
+	h.Cap = len(bytes) / 8
+	h.Len = h.Cap
+	return
+}
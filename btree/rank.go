@@ -0,0 +1,63 @@
+package btree
+
+// Order statistics. Internal nodes count the keys under each of
+// their references, so these descend the tree once, and only look at
+// the counts in the pages on the way down.
+
+// Number of keys smaller than key.
+func (b *Btree) Rank(key []byte) (rank int64) {
+	page := b.pager.Get(b.root)
+	for !page.IsLeaf() {
+		k, _ := page.Search(key)
+		for i := 0; i < k.Pos(); i++ {
+			rank += page.Count(i)
+		}
+		page = b.pager.Get(k.Ref())
+	}
+
+	k, _ := page.Search(key)
+	if k.Pos() < 0 {
+		// greater than everything in the leaf
+		return rank + int64(page.Size())
+	}
+	return rank + int64(k.Pos())
+}
+
+// Number of keys k with start <= k < end. A nil end means no upper
+// bound.
+func (b *Btree) Count(start, end []byte) int64 {
+	last := b.Size()
+	if end != nil {
+		last = b.Rank(end)
+	}
+	first := b.Rank(start)
+	if last < first {
+		return 0
+	}
+	return last - first
+}
+
+// Number of keys that start with prefix.
+func (b *Btree) CountPrefix(prefix []byte) int64 {
+	return b.Count(prefix, prefixEnd(prefix))
+}
+
+// The i-th key, counting from 0, and its value.
+func (b *Btree) Select(i int64) (key []byte, value []byte, ok bool) {
+	if i < 0 || i >= b.Size() {
+		return
+	}
+
+	page := b.pager.Get(b.root)
+	for !page.IsLeaf() {
+		j := 0
+		for ; j < page.Size()-1 && i >= page.Count(j); j++ {
+			i -= page.Count(j)
+		}
+		_, ref := page.GetKey(j)
+		page = b.pager.Get(ref)
+	}
+
+	key, ref := page.GetKey(int(i))
+	return key, page.GetValue(ref), true
+}
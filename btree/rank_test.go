@@ -0,0 +1,292 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+func bigEndianKey(i int) []byte {
+	k := make([]byte, 4)
+	binary.BigEndian.PutUint32(k, uint32(i))
+	return k
+}
+
+// Trees with the even numbers below 2n, put in random order and in
+// order.
+func rankTrees(n int) []*Btree {
+	random := NewInMemoryBtree().(*Btree)
+	for _, i := range rand.Perm(n) {
+		random.Put(bigEndianKey(2*i), bigEndianKey(i))
+	}
+	ordered := NewInMemoryBtree().(*Btree)
+	for i := 0; i < n; i++ {
+		ordered.PutNext(bigEndianKey(2*i), bigEndianKey(i))
+	}
+	return []*Btree{random, ordered}
+}
+
+func TestRankAndSelect(t *testing.T) {
+	const n = 50000
+	for _, index := range rankTrees(n) {
+		defer index.Dispose()
+		if err := index.CheckConsistency(); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < n; i += 7 {
+			if r := index.Rank(bigEndianKey(2 * i)); r != int64(i) {
+				t.Fatal("Expected rank", i, "got", r)
+			}
+			if r := index.Rank(bigEndianKey(2*i + 1)); r != int64(i+1) {
+				t.Fatal("Expected rank", i+1, "got", r)
+			}
+			k, v, ok := index.Select(int64(i))
+			if !ok || !bytes.Equal(k, bigEndianKey(2*i)) || !bytes.Equal(v, bigEndianKey(i)) {
+				t.Fatal("Expected", bigEndianKey(2*i), "got", k, v, ok)
+			}
+		}
+
+		if r := index.Rank([]byte{}); r != 0 {
+			t.Fatal("Expected rank 0, got", r)
+		}
+		if r := index.Rank([]byte{0xff}); r != n {
+			t.Fatal("Expected rank", n, "got", r)
+		}
+		if _, _, ok := index.Select(n); ok {
+			t.Fatal("Did not expect to select past the end")
+		}
+		if _, _, ok := index.Select(-1); ok {
+			t.Fatal("Did not expect to select before the start")
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	const n = 50000
+	for _, index := range rankTrees(n) {
+		defer index.Dispose()
+
+		if c := index.Count([]byte{}, nil); c != n {
+			t.Fatal("Expected", n, "got", c)
+		}
+		if c := index.Count(bigEndianKey(100), bigEndianKey(200)); c != 50 {
+			t.Fatal("Expected 50, got", c)
+		}
+		if c := index.Count(bigEndianKey(200), bigEndianKey(100)); c != 0 {
+			t.Fatal("Expected 0, got", c)
+		}
+		if c := index.Count(bigEndianKey(2*n-2), nil); c != 1 {
+			t.Fatal("Expected 1, got", c)
+		}
+
+		// keys 0x00 0x00 0x01 0x00 to 0x00 0x00 0x01 0xfe
+		if c := index.CountPrefix([]byte{0, 0, 1}); c != 128 {
+			t.Fatal("Expected 128, got", c)
+		}
+		if c := index.CountPrefix([]byte{0}); c != n {
+			t.Fatal("Expected", n, "got", c)
+		}
+		if c := index.CountPrefix([]byte{1}); c != 0 {
+			t.Fatal("Expected 0, got", c)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for _, c := range []struct{ prefix, end []byte }{
+		{[]byte{}, nil},
+		{[]byte{1}, []byte{2}},
+		{[]byte{1, 0xff}, []byte{2}},
+		{[]byte{0xff, 0xff}, nil},
+		{[]byte{1, 2, 3}, []byte{1, 2, 4}},
+	} {
+		if end := prefixEnd(c.prefix); !bytes.Equal(end, c.end) || (end == nil) != (c.end == nil) {
+			t.Fatal("Expected", c.end, "got", end, "for", c.prefix)
+		}
+	}
+}
+
+// Counts the leaf pages that are read.
+type leafCountingPager struct {
+	Pager
+	leafGets int
+}
+
+func (p *leafCountingPager) Get(ref int64) Page {
+	page := p.Pager.Get(ref)
+	if page.IsLeaf() {
+		p.leafGets++
+	}
+	return page
+}
+
+func TestApproximateCount(t *testing.T) {
+	const n = 200000
+	for _, index := range rankTrees(n) {
+		defer index.Dispose()
+		pager := &leafCountingPager{Pager: index.pager}
+		index.pager = pager
+
+		for _, r := range [][2]int{{0, 2 * n}, {1000, 3000}, {5000, 105000}, {2*n - 5000, 2 * n}, {77777, 177777}} {
+			start, end := bigEndianKey(r[0]), bigEndianKey(r[1])
+			exact := index.Count(start, end)
+			pager.leafGets = 0
+			approx := index.ApproximateCount(start, end)
+			if pager.leafGets != 0 {
+				t.Fatal("Expected no leaf pages to be read, got", pager.leafGets)
+			}
+			if diff := approx - exact; diff*diff > (exact/20+100)*(exact/20+100) {
+				t.Fatal("Expected about", exact, "keys in", r, "got", approx)
+			}
+			t.Log(r, "exact:", exact, "approximate:", approx)
+		}
+
+		if c := index.ApproximateCount([]byte{}, nil); c != n {
+			t.Fatal("Expected", n, "got", c)
+		}
+		if c := index.ApproximateCount(bigEndianKey(500), bigEndianKey(100)); c != 0 {
+			t.Fatal("Expected 0, got", c)
+		}
+
+		all := index.ApproximateBytes([]byte{}, nil)
+		usage := index.MemoryUsage()
+		if all != usage.PageBytes+usage.ValueStoreBytes {
+			t.Fatal("Expected all the bytes, got", all, "of", usage)
+		}
+		half := index.ApproximateBytes([]byte{}, bigEndianKey(n))
+		if half < all*4/10 || half > all*6/10 {
+			t.Fatal("Expected about half of", all, "got", half)
+		}
+	}
+}
+
+// Keys like field\x00value share a prefix longer than the 8 bytes
+// interpolation looks at.
+func TestApproximateCountSharedPrefix(t *testing.T) {
+	const n = 100000
+	index := NewInMemoryBtree().(*Btree)
+	defer index.Dispose()
+	key := func(i int) []byte {
+		return append([]byte("hostname\x00"), bigEndianKey(i)...)
+	}
+	for _, i := range rand.Perm(n) {
+		index.Put(key(i), bigEndianKey(i))
+	}
+	// the last subtree, which has no separator after it
+	for i := n - 2000; i < n; i += 37 {
+		exact := int64(n - i)
+		approx := index.ApproximateCount(key(i), nil)
+		if diff := approx - exact; diff*diff > (exact/10+10)*(exact/10+10) {
+			t.Fatal("Expected about", exact, "keys from", i, "got", approx)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	for _, c := range []struct {
+		lo, hi, key []byte
+		expected    float64
+	}{
+		{[]byte{1, 0}, []byte{1, 0x80}, []byte{1, 0x40}, 0.5},
+		{[]byte{1, 0}, []byte{2, 0}, []byte{1, 0}, 0},
+		{[]byte{}, nil, []byte{0x80}, 0.5},
+		{[]byte{5, 5, 0}, []byte{5, 5, 100}, []byte{5, 5, 25}, 0.25},
+		{[]byte{5}, []byte{5}, []byte{5}, 0.5},
+	} {
+		if f := interpolate(c.lo, c.hi, c.key); f < c.expected-0.01 || f > c.expected+0.01 {
+			t.Fatal("Expected", c.expected, "got", f, "for", c.lo, c.hi, c.key)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	const n = 10000
+	r := rand.New(rand.NewSource(42))
+	for _, index := range rankTrees(n) {
+		defer index.Dispose()
+
+		// samples from the 1000 keys in [2000, 4000), counted in
+		// 10 buckets
+		buckets := make([]int, 10)
+		it := index.Sample(r, 20000, bigEndianKey(2000), bigEndianKey(4000))
+		samples := 0
+		for {
+			k, v, ok := it.Next()
+			if !ok {
+				break
+			}
+			i := int(binary.BigEndian.Uint32(k))
+			if i < 2000 || i >= 4000 || int(binary.BigEndian.Uint32(v)) != i/2 {
+				t.Fatal("Sample out of range:", k, v)
+			}
+			buckets[(i-2000)/200]++
+			samples++
+		}
+		if samples != 20000 {
+			t.Fatal("Expected 20000 samples, got", samples)
+		}
+		for _, c := range buckets {
+			if c < 1600 || c > 2400 {
+				t.Fatal("Expected about 2000 samples per bucket, got", buckets)
+			}
+		}
+
+		// prefix 0x00 0x00 0x01 holds 128 keys
+		it = index.SamplePrefix(r, 100, []byte{0, 0, 1})
+		for {
+			k, _, ok := it.Next()
+			if !ok {
+				break
+			}
+			if !bytes.HasPrefix(k, []byte{0, 0, 1}) {
+				t.Fatal("Expected prefix 0 0 1, got", k)
+			}
+		}
+
+		if _, _, ok := index.Sample(r, 10, []byte{1}, nil).Next(); ok {
+			t.Fatal("Did not expect samples from an empty range")
+		}
+	}
+}
+
+func TestSampleStratified(t *testing.T) {
+	const n = 10000
+	r := rand.New(rand.NewSource(42))
+	for _, index := range rankTrees(n) {
+		defer index.Dispose()
+
+		it := index.SampleStratified(r, 100, []byte{}, nil)
+		prev := -1
+		count := 0
+		for {
+			k, _, ok := it.Next()
+			if !ok {
+				break
+			}
+			i := int(binary.BigEndian.Uint32(k)) / 2
+			if i <= prev || i/100 != count {
+				t.Fatal("Expected sample", count, "from its own stratum in order, got", i, "after", prev)
+			}
+			prev = i
+			count++
+		}
+		if count != 100 {
+			t.Fatal("Expected 100 samples, got", count)
+		}
+
+		// more strata than keys
+		it = index.SampleStratified(r, 100, bigEndianKey(0), bigEndianKey(20))
+		count = 0
+		for {
+			if _, _, ok := it.Next(); !ok {
+				break
+			}
+			count++
+		}
+		if count != 10 {
+			t.Fatal("Expected every one of the 10 keys, got", count)
+		}
+	}
+}
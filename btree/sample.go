@@ -0,0 +1,74 @@
+package btree
+
+import (
+	"math/rand"
+
+	"github.com/avisagie/indexes"
+)
+
+// Random samples of the keys in a range. Each sample descends the
+// tree once, choosing children in proportion to the counts of keys
+// under them, so nothing gets scanned.
+
+type sampleIter struct {
+	b          *Btree
+	r          *rand.Rand
+	first      int64
+	count      int64
+	n, i       int
+	stratified bool
+}
+
+func (s *sampleIter) Next() (key []byte, value []byte, ok bool) {
+	if s.i >= s.n || s.count == 0 {
+		return
+	}
+
+	var rank int64
+	if s.stratified {
+		lo := int64(s.i) * s.count / int64(s.n)
+		hi := int64(s.i+1) * s.count / int64(s.n)
+		rank = s.first + lo + s.r.Int63n(hi-lo)
+	} else {
+		rank = s.first + s.r.Int63n(s.count)
+	}
+	s.i++
+
+	return s.b.Select(rank)
+}
+
+func (b *Btree) newSampleIter(r *rand.Rand, n int, start, end []byte, stratified bool) *sampleIter {
+	first := b.Rank(start)
+	last := b.Size()
+	if end != nil {
+		last = b.Rank(end)
+	}
+	count := last - first
+	if count < 0 {
+		count = 0
+	}
+	if stratified && int64(n) > count {
+		n = int(count)
+	}
+	return &sampleIter{b, r, first, count, n, 0, stratified}
+}
+
+// n keys and their values drawn uniformly and with replacement from
+// those k with start <= k < end, using r. A nil end means no upper
+// bound.
+func (b *Btree) Sample(r *rand.Rand, n int, start, end []byte) indexes.Iter {
+	return b.newSampleIter(r, n, start, end, false)
+}
+
+// Like Sample, but splits the range into n slices with the same
+// number of keys and draws one key from each, so the samples come out
+// in order and without repeats. Returns fewer samples if the range
+// has fewer than n keys.
+func (b *Btree) SampleStratified(r *rand.Rand, n int, start, end []byte) indexes.Iter {
+	return b.newSampleIter(r, n, start, end, true)
+}
+
+// Sample the keys that start with prefix.
+func (b *Btree) SamplePrefix(r *rand.Rand, n int, prefix []byte) indexes.Iter {
+	return b.Sample(r, n, prefix, prefixEnd(prefix))
+}
@@ -0,0 +1,58 @@
+package btree
+
+// Decides where a full page splits. sizes are the bytes taken by each
+// entry in the page, and insert is where the key that did not fit
+// goes among them. Returns the number of entries that stay in the
+// page; the rest move to a new page to its right. The page moves the
+// split as little as it can to leave room for the key where it goes.
+type SplitPolicy func(sizes []int, insert int) int
+
+// Half the entries in each page. The default.
+func SplitByCount(sizes []int, insert int) int {
+	return len(sizes) / 2
+}
+
+// Half the bytes in each page, which evens out pages with keys of
+// different sizes.
+func SplitByBytes(sizes []int, insert int) int {
+	return splitAtFraction(sizes, 0.5)
+}
+
+// Keeps fillFactor of the bytes in the page, from 0 to 1. A high one
+// suits keys that mostly go to the right of the keys already there.
+func FillFactor(fillFactor float64) SplitPolicy {
+	return func(sizes []int, insert int) int {
+		return splitAtFraction(sizes, fillFactor)
+	}
+}
+
+// For keys that mostly ascend: when the key goes after everything in
+// the page, leaves the page as full as it can be and starts the new
+// one with the key, as PutNext does. Otherwise splits by bytes.
+func SplitRightmost(sizes []int, insert int) int {
+	if insert == len(sizes) {
+		return len(sizes)
+	}
+	return SplitByBytes(sizes, insert)
+}
+
+// How Put splits the rightmost leaf in a run of mostly ascending keys:
+// with room for the keys that arrive late.
+var runFill = FillFactor(0.9)
+
+// The number of entries before fraction of the bytes.
+func splitAtFraction(sizes []int, fraction float64) int {
+	total := 0
+	for _, s := range sizes {
+		total += s
+	}
+	target := int(fraction * float64(total))
+	sum := 0
+	for i, s := range sizes {
+		if sum+s/2 > target {
+			return i
+		}
+		sum += s
+	}
+	return len(sizes)
+}
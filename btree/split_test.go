@@ -0,0 +1,100 @@
+package btree
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/indexestest"
+)
+
+var splitPolicies = map[string]SplitPolicy{
+	"count":     SplitByCount,
+	"bytes":     SplitByBytes,
+	"rightmost": SplitRightmost,
+	"fill90":    FillFactor(0.9),
+	"fill10":    FillFactor(0.1),
+}
+
+func TestSplitPoliciesConformance(t *testing.T) {
+	opts := conformance
+	opts.Ops = 5000
+	for name, policy := range splitPolicies {
+		policy := policy
+		t.Run(name, func(t *testing.T) {
+			indexestest.Run(t, func() indexes.Index {
+				return NewInMemoryBtreeOptions(Options{SplitPolicy: policy})
+			}, opts)
+		})
+	}
+}
+
+// Fill a tree with keys of random sizes in random order, or with
+// descending keys, and return its fill rate. Put leaves ascending
+// keys to PutNext's way of splitting whatever the policy, but
+// descending ones all go to the front of the first leaf.
+func fillRate(t *testing.T, policy SplitPolicy, descending bool) float64 {
+	index := NewInMemoryBtreeOptions(Options{SplitPolicy: policy}).(*Btree)
+	defer index.Dispose()
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50000; i++ {
+		var k []byte
+		if descending {
+			k = make([]byte, 8)
+			binary.BigEndian.PutUint64(k, uint64(50000-i))
+		} else {
+			k = make([]byte, 4+r.Intn(200))
+			r.Read(k)
+		}
+		index.Put(k, []byte{1})
+	}
+	if err := index.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+	return index.Stats().FillRate
+}
+
+func TestSplitPolicyFillRates(t *testing.T) {
+	for name, policy := range splitPolicies {
+		t.Log(name, "descending:", fillRate(t, policy, true), "random:", fillRate(t, policy, false))
+	}
+
+	if f := fillRate(t, SplitByCount, true); f > 0.6 {
+		t.Error("Expected descending keys to leave pages half full splitting by count, got", f)
+	}
+	if f := fillRate(t, FillFactor(0.1), true); f < 0.8 {
+		t.Error("Expected descending keys to fill pages keeping 0.1 on the left, got", f)
+	}
+	if f := fillRate(t, SplitByBytes, false); f < 0.6 {
+		t.Error("Expected random keys to fill pages to about 0.7 splitting by bytes, got", f)
+	}
+}
+
+func TestSplitRightmost(t *testing.T) {
+	sizes := []int{10, 10, 10, 10}
+	if got := SplitRightmost(sizes, 4); got != 4 {
+		t.Error("Expected to keep everything for a key at the end, got", got)
+	}
+	if got := SplitRightmost(sizes, 3); got != 2 {
+		t.Error("Expected to split by bytes for a key before the end, got", got)
+	}
+}
+
+func TestSplitAtFraction(t *testing.T) {
+	for _, c := range []struct {
+		sizes    []int
+		fraction float64
+		want     int
+	}{
+		{[]int{10, 10, 10, 10}, 0.5, 2},
+		{[]int{100, 10, 10, 10}, 0.5, 1},
+		{[]int{10, 10, 10, 100}, 0.5, 3},
+		{[]int{10, 10, 10, 10}, 0.9, 4},
+		{[]int{10, 10, 10, 10}, 0, 0},
+	} {
+		if got := splitAtFraction(c.sizes, c.fraction); got != c.want {
+			t.Error("Expected", c.want, "for", c.sizes, c.fraction, "got", got)
+		}
+	}
+}
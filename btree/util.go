@@ -31,3 +31,26 @@ func writeInt32(data []byte, offset int, i int32) {
 	data[offset+2] = byte((i >> 16) & 0xFF)
 	data[offset+3] = byte((i >> 24) & 0xFF)
 }
+
+// The shortest prefix of right that is greater than left, for a
+// separator between two pages. left must be less than right.
+func separator(left, right []byte) []byte {
+	common := 0
+	for common < len(left) && left[common] == right[common] {
+		common++
+	}
+	return right[:common+1]
+}
+
+// The smallest key that is greater than all keys with this prefix,
+// nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := copyBytes(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// A portable format for the contents of an index, independent of how
+// any index lays out its pages. Export writes the keys and values from
+// an indexes.Iter, Import reads them back in the same order, so that
+// they can go straight into PutNext:
+//
+//	n, err := dump.Export(index.Start(nil), w)
+//	...
+//	n, err = dump.Restore(r, btree.NewInMemoryBtree().(*btree.Btree))
+//
+// The stream starts with Magic. Each record is the length of the key
+// plus one as a uvarint, the key, the length of the value as a
+// uvarint, the value, and a big endian CRC-32C of the key and value.
+// A zero length ends the stream, followed by the number of records as
+// a uvarint, so that a truncated stream is an error rather than a
+// shorter index.
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/internal/bounded"
+)
+
+const Magic = "indexes dump 1\n"
+
+// Longest key and value Import accepts, well past what any index
+// here takes. Longer ones are taken to mean the stream is corrupt.
+const (
+	MaxKeySize   = 64 << 10
+	MaxValueSize = 1 << 30
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func checksum(key, value []byte) uint32 {
+	return crc32.Update(crc32.Checksum(key, castagnoli), castagnoli, value)
+}
+
+// Write the keys and values from it to w. Returns the number of
+// records written.
+func Export(it indexes.Iter, w io.Writer) (n int64, err error) {
+	bw := bufio.NewWriter(w)
+	if _, err = bw.WriteString(Magic); err != nil {
+		return
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v uint64) {
+		if err == nil {
+			_, err = bw.Write(buf[:binary.PutUvarint(buf[:], v)])
+		}
+	}
+	write := func(b []byte) {
+		if err == nil {
+			_, err = bw.Write(b)
+		}
+	}
+
+	for {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		if len(k) == 0 || len(v) == 0 || len(k) > MaxKeySize || len(v) > MaxValueSize {
+			return n, fmt.Errorf("record %d: key or value empty or too long to import again", n)
+		}
+		writeUvarint(uint64(len(k)) + 1)
+		write(k)
+		writeUvarint(uint64(len(v)))
+		write(v)
+		var crc [4]byte
+		binary.BigEndian.PutUint32(crc[:], checksum(k, v))
+		write(crc[:])
+		if err != nil {
+			return
+		}
+		n++
+	}
+
+	writeUvarint(0)
+	writeUvarint(uint64(n))
+	if err != nil {
+		return
+	}
+	return n, bw.Flush()
+}
+
+// Reads a stream written by Export, or ExportJSON. It is an
+// indexes.Iter: Next returns the records in order, and ok is false at
+// the end of the stream or on an error. Err tells which.
+type Importer struct {
+	r     *bufio.Reader
+	next  func(*Importer) (key, value []byte, err error)
+	key   []byte
+	value []byte
+	n     int64
+	err   error
+	// past Magic
+	started bool
+	done    bool
+}
+
+// Read a stream written by Export. The slices Next returns are only
+// valid until the next call.
+func Import(r io.Reader) *Importer {
+	return &Importer{r: bufio.NewReader(r), next: (*Importer).readRecord}
+}
+
+func (im *Importer) Next() (key []byte, value []byte, ok bool) {
+	if im.done {
+		return
+	}
+	key, value, err := im.next(im)
+	if err == nil && key != nil && (len(key) == 0 || len(value) == 0) {
+		// PutNext would panic
+		err = fmt.Errorf("record %d: empty key or value", im.n)
+	}
+	if err == nil && key != nil && im.n > 0 && bytes.Compare(key, im.key) <= 0 {
+		err = fmt.Errorf("record %d: keys out of order", im.n)
+	}
+	if err != nil || key == nil {
+		im.err = err
+		im.done = true
+		return nil, nil, false
+	}
+	im.key = append(im.key[:0], key...)
+	im.n++
+	return key, value, true
+}
+
+// The error that stopped Next, if any.
+func (im *Importer) Err() error {
+	return im.err
+}
+
+// Number of records read so far.
+func (im *Importer) Count() int64 {
+	return im.n
+}
+
+var errMagic = errors.New("not an index dump")
+
+func (im *Importer) readRecord() (key, value []byte, err error) {
+	if !im.started {
+		magic := make([]byte, len(Magic))
+		if _, err := io.ReadFull(im.r, magic); err != nil || string(magic) != Magic {
+			return nil, nil, errMagic
+		}
+		im.started = true
+	}
+
+	l, err := binary.ReadUvarint(im.r)
+	if err != nil {
+		return nil, nil, im.corrupt(err)
+	}
+	if l == 0 {
+		count, err := binary.ReadUvarint(im.r)
+		if err != nil {
+			return nil, nil, im.corrupt(err)
+		}
+		if int64(count) != im.n {
+			return nil, nil, fmt.Errorf("expected %d records, read %d", count, im.n)
+		}
+		return nil, nil, nil
+	}
+
+	if l-1 > MaxKeySize {
+		return nil, nil, fmt.Errorf("record %d: key length %d is more than %d", im.n, l-1, MaxKeySize)
+	}
+	key = make([]byte, l-1)
+	if _, err := io.ReadFull(im.r, key); err != nil {
+		return nil, nil, im.corrupt(err)
+	}
+	l, err = binary.ReadUvarint(im.r)
+	if err != nil {
+		return nil, nil, im.corrupt(err)
+	}
+	if l > MaxValueSize {
+		return nil, nil, fmt.Errorf("record %d: value length %d is more than %d", im.n, l, MaxValueSize)
+	}
+	if im.value, err = bounded.ReadFull(im.r, im.value, int(l)); err != nil {
+		return nil, nil, im.corrupt(err)
+	}
+	value = im.value
+	var crc [4]byte
+	if _, err := io.ReadFull(im.r, crc[:]); err != nil {
+		return nil, nil, im.corrupt(err)
+	}
+	if binary.BigEndian.Uint32(crc[:]) != checksum(key, value) {
+		return nil, nil, fmt.Errorf("record %d: checksum mismatch", im.n)
+	}
+	return key, value, nil
+}
+
+func (im *Importer) corrupt(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("record %d: %v", im.n, err)
+}
+
+// Import from r into index. Returns the number of records.
+func Restore(r io.Reader, index indexes.PutableInOrder) (int64, error) {
+	im := Import(r)
+	for {
+		k, v, ok := im.Next()
+		if !ok {
+			break
+		}
+		index.PutNext(k, v)
+	}
+	return im.Count(), im.Err()
+}
@@ -0,0 +1,183 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/avisagie/indexes/btree"
+)
+
+func testTree(n int) *btree.Btree {
+	index := btree.NewInMemoryBtree().(*btree.Btree)
+	for i := 0; i < n; i++ {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, uint64(i*7))
+		index.Put(k, []byte(fmt.Sprint("value ", i, "\xff")))
+	}
+	index.Put([]byte("text"), []byte("plain"))
+	return index
+}
+
+func sameContents(t *testing.T, a, b *btree.Btree) {
+	if a.Size() != b.Size() {
+		t.Fatal("expected", a.Size(), "keys, got", b.Size())
+	}
+	ia, ib := a.Start(nil), b.Start(nil)
+	for {
+		ka, va, oka := ia.Next()
+		kb, vb, okb := ib.Next()
+		if oka != okb || !bytes.Equal(ka, kb) || !bytes.Equal(va, vb) {
+			t.Fatal("expected", ka, va, oka, "got", kb, vb, okb)
+		}
+		if !oka {
+			return
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	index := testTree(10000)
+	defer index.Dispose()
+
+	for _, format := range []struct {
+		name string
+		exp  func(*btree.Btree, io.Writer) (int64, error)
+		imp  func(io.Reader) *Importer
+	}{
+		{"binary", func(b *btree.Btree, w io.Writer) (int64, error) { return Export(b.Start(nil), w) }, Import},
+		{"json", func(b *btree.Btree, w io.Writer) (int64, error) { return ExportJSON(b.Start(nil), w) }, ImportJSON},
+	} {
+		buf := &bytes.Buffer{}
+		n, err := format.exp(index, buf)
+		if err != nil || n != index.Size() {
+			t.Fatal(format.name, "expected", index.Size(), "records, got", n, err)
+		}
+
+		restored := btree.NewInMemoryBtree().(*btree.Btree)
+		im := format.imp(buf)
+		for {
+			k, v, ok := im.Next()
+			if !ok {
+				break
+			}
+			restored.PutNext(k, v)
+		}
+		if im.Err() != nil {
+			t.Fatal(format.name, im.Err())
+		}
+		sameContents(t, index, restored)
+		restored.Dispose()
+	}
+}
+
+func TestRestore(t *testing.T) {
+	index := testTree(1000)
+	defer index.Dispose()
+	buf := &bytes.Buffer{}
+	if _, err := Export(index.Start(nil), buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := btree.NewInMemoryBtree().(*btree.Btree)
+	defer restored.Dispose()
+	n, err := Restore(buf, restored)
+	if err != nil || n != index.Size() {
+		t.Fatal("expected", index.Size(), "got", n, err)
+	}
+	sameContents(t, index, restored)
+}
+
+func importErr(data []byte) error {
+	im := Import(bytes.NewReader(data))
+	for {
+		if _, _, ok := im.Next(); !ok {
+			return im.Err()
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	index := testTree(100)
+	defer index.Dispose()
+	buf := &bytes.Buffer{}
+	Export(index.Start(nil), buf)
+	data := buf.Bytes()
+
+	if err := importErr(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := importErr([]byte("something else")); err != errMagic {
+		t.Error("expected", errMagic, "got", err)
+	}
+
+	// every truncation is noticed
+	for i := 0; i < len(data); i++ {
+		if err := importErr(data[:i]); err == nil {
+			t.Fatal("expected an error for", i, "of", len(data), "bytes")
+		}
+	}
+
+	flipped := append([]byte{}, data...)
+	flipped[len(Magic)+5] ^= 1
+	if err := importErr(flipped); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Error("expected a checksum error, got", err)
+	}
+
+	// lengths past the limits are corrupt, and do not allocate them
+	for _, lengths := range [][]uint64{{MaxKeySize + 2}, {2, 'a', MaxValueSize + 1}, {2, 'a', 1 << 29}} {
+		b := []byte(Magic)
+		for _, l := range lengths {
+			b = binary.AppendUvarint(b, l)
+		}
+		if err := importErr(b); err == nil {
+			t.Error("expected an error for lengths", lengths)
+		}
+	}
+
+	// keys must come in order for PutNext
+	unsorted := &bytes.Buffer{}
+	Export(&sliceIter{[][2]string{{"b", "1"}, {"a", "2"}}}, unsorted)
+	if err := importErr(unsorted.Bytes()); err == nil || !strings.Contains(err.Error(), "order") {
+		t.Error("expected keys out of order, got", err)
+	}
+
+	// nor can they be empty
+	for _, kv := range [][2]string{{"", "1"}, {"a", ""}} {
+		b := []byte(Magic)
+		b = binary.AppendUvarint(b, uint64(len(kv[0])+1))
+		b = append(b, kv[0]...)
+		b = binary.AppendUvarint(b, uint64(len(kv[1])))
+		b = append(b, kv[1]...)
+		b = binary.BigEndian.AppendUint32(b, checksum([]byte(kv[0]), []byte(kv[1])))
+		if err := importErr(b); err == nil || !strings.Contains(err.Error(), "empty") {
+			t.Error("expected an empty key or value for", kv, "got", err)
+		}
+	}
+	if _, err := Export(&sliceIter{[][2]string{{"a", ""}}}, &bytes.Buffer{}); err == nil {
+		t.Error("expected an error exporting an empty value")
+	}
+	for _, line := range []string{`{"key":"","value":"1"}`, `{"key_hex":"","value":"1"}`, `{"key":"a","value_hex":""}`} {
+		im := ImportJSON(strings.NewReader(line + "\n"))
+		if _, _, ok := im.Next(); ok || im.Err() == nil {
+			t.Error("expected an error for", line)
+		}
+	}
+}
+
+type sliceIter struct {
+	kvs [][2]string
+}
+
+func (s *sliceIter) Next() (key []byte, value []byte, ok bool) {
+	if len(s.kvs) == 0 {
+		return
+	}
+	kv := s.kvs[0]
+	s.kvs = s.kvs[1:]
+	return []byte(kv[0]), []byte(kv[1]), true
+}
@@ -0,0 +1,88 @@
+package dump
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/avisagie/indexes"
+)
+
+// A record in the JSON lines format. Keys and values that are valid
+// UTF-8 go in Key and Value, others in hex in KeyHex and ValueHex.
+type jsonRecord struct {
+	Key      *string `json:"key,omitempty"`
+	KeyHex   *string `json:"key_hex,omitempty"`
+	Value    *string `json:"value,omitempty"`
+	ValueHex *string `json:"value_hex,omitempty"`
+}
+
+func jsonField(b []byte) (text *string, hexed *string) {
+	s := string(b)
+	if utf8.ValidString(s) {
+		return &s, nil
+	}
+	s = hex.EncodeToString(b)
+	return nil, &s
+}
+
+func fromJSON(text, hexed *string) ([]byte, error) {
+	switch {
+	case text != nil && hexed == nil:
+		return []byte(*text), nil
+	case hexed != nil && text == nil:
+		return hex.DecodeString(*hexed)
+	}
+	return nil, fmt.Errorf("expected one of text or hex")
+}
+
+// Write the keys and values from it to w as a JSON object per line,
+// for people to read. There is no checksum.
+func ExportJSON(it indexes.Iter, w io.Writer) (n int64, err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		var rec jsonRecord
+		rec.Key, rec.KeyHex = jsonField(k)
+		rec.Value, rec.ValueHex = jsonField(v)
+		if err = enc.Encode(&rec); err != nil {
+			return
+		}
+		n++
+	}
+	return n, bw.Flush()
+}
+
+// Read a stream written by ExportJSON.
+func ImportJSON(r io.Reader) *Importer {
+	return &Importer{r: bufio.NewReader(r), next: (*Importer).readJSON}
+}
+
+func (im *Importer) readJSON() (key, value []byte, err error) {
+	line, err := im.r.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return nil, nil, nil
+	}
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+
+	var rec jsonRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return nil, nil, fmt.Errorf("line %d: %v", im.n+1, err)
+	}
+	if key, err = fromJSON(rec.Key, rec.KeyHex); err != nil {
+		return nil, nil, fmt.Errorf("line %d: key: %v", im.n+1, err)
+	}
+	if value, err = fromJSON(rec.Value, rec.ValueHex); err != nil {
+		return nil, nil, fmt.Errorf("line %d: value: %v", im.n+1, err)
+	}
+	return key, value, nil
+}
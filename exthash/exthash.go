@@ -0,0 +1,388 @@
+// Extendible hashing, for fields that are only ever looked up by
+// exact key. A directory of 2^depth entries points at fixed size
+// buckets by the low bits of a key's hash. A full bucket splits in
+// two on one more bit of the hash, and the directory doubles when a
+// bucket needs more bits than it has. Only the one bucket is touched
+// on a split, and buckets are fixed size pages, which suits writing
+// them out to disk. Values live in a malloc.Region beside the
+// buckets.
+//
+// There is no order to the keys, so Start finds nothing. Each visits
+// every key, in no particular order.
+package exthash
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/malloc"
+)
+
+const (
+	bucketSize = 4 << 10
+
+	// Buckets start with:
+	//
+	//	uint8   local depth: the number of hash bits its keys share
+	//	uint16  number of entries
+	//	uint32  bytes used, including this header
+	//
+	// Entries are the key's hash as a uint64, a reference to the
+	// value (a uvarint length and the bytes) as an int64, the
+	// length of the key as a uint16 and the key, padded to 8 bytes.
+	depthOffset = 0
+	countOffset = 2
+	usedOffset  = 4
+	headerSize  = 8
+
+	entryHash   = 0
+	entryValue  = 8
+	entryKeyLen = 16
+	entryKey    = 18
+
+	// beyond this, keys collide on every bit we look at
+	maxDepth = 40
+)
+
+// Keys are at most this long, so that a bucket holds a few of them.
+const MaxKeySize = bucketSize/4 - entryKey
+
+var ErrKeyTooLarge = errors.New("exthash: key larger than MaxKeySize")
+
+// Satisfies indexes.Index.
+type Hash struct {
+	alloc   malloc.Allocator
+	values  *malloc.Region
+	buckets [][]byte
+	dir     []int32
+	depth   uint
+	size    int64
+}
+
+type Options struct {
+	// Where buckets and values are allocated. Defaults to
+	// malloc.Default.
+	Allocator malloc.Allocator
+
+	// Size the directory for this many keys up front, to save
+	// splitting buckets while building.
+	ExpectedKeys int64
+}
+
+func NewHash() indexes.Index {
+	return NewHashOptions(Options{})
+}
+
+func NewHashOptions(opts Options) indexes.Index {
+	if opts.Allocator == nil {
+		opts.Allocator = malloc.Default
+	}
+	h := &Hash{alloc: opts.Allocator, values: malloc.NewRegion(opts.Allocator)}
+
+	// guess 32 byte entries at two thirds full
+	depth := uint(0)
+	for opts.ExpectedKeys*32*3/2 > int64(bucketSize)<<depth && depth < maxDepth/2 {
+		depth++
+	}
+	h.depth = depth
+	h.dir = make([]int32, 1<<depth)
+	for i := range h.dir {
+		h.dir[i] = h.newBucket(depth)
+	}
+	return h
+}
+
+// Put everything from it into a new Hash, in one pass.
+func Build(it indexes.Iter, opts Options) *Hash {
+	h := NewHashOptions(opts).(*Hash)
+	for {
+		k, v, ok := it.Next()
+		if !ok {
+			return h
+		}
+		h.Put(k, v)
+	}
+}
+
+func (h *Hash) newBucket(depth uint) int32 {
+	b := h.alloc.Malloc(bucketSize)
+	b[depthOffset] = byte(depth)
+	binary.LittleEndian.PutUint16(b[countOffset:], 0)
+	binary.LittleEndian.PutUint32(b[usedOffset:], headerSize)
+	h.buckets = append(h.buckets, b)
+	return int32(len(h.buckets) - 1)
+}
+
+// 64 bit FNV-1a.
+func hash(key []byte) uint64 {
+	x := uint64(14695981039346656037)
+	for _, c := range key {
+		x ^= uint64(c)
+		x *= 1099511628211
+	}
+	return x
+}
+
+func entrySize(keyLen int) int {
+	return (entryKey + keyLen + 7) &^ 7
+}
+
+func used(b []byte) int {
+	return int(binary.LittleEndian.Uint32(b[usedOffset:]))
+}
+
+// Call f with the offset of each entry in b, until it returns false.
+func eachEntry(b []byte, f func(e int) bool) {
+	for e := headerSize; e < used(b); {
+		if !f(e) {
+			return
+		}
+		e += entrySize(int(binary.LittleEndian.Uint16(b[e+entryKeyLen:])))
+	}
+}
+
+func entryKeyBytes(b []byte, e int) []byte {
+	l := int(binary.LittleEndian.Uint16(b[e+entryKeyLen:]))
+	return b[e+entryKey : e+entryKey+l]
+}
+
+// The bucket for a hash, and the offset of the entry for key in it,
+// or -1.
+func (h *Hash) find(key []byte, hk uint64) (b []byte, entry int) {
+	b = h.buckets[h.dir[hk&(1<<h.depth-1)]]
+	entry = -1
+	eachEntry(b, func(e int) bool {
+		if binary.LittleEndian.Uint64(b[e+entryHash:]) == hk && string(entryKeyBytes(b, e)) == string(key) {
+			entry = e
+			return false
+		}
+		return true
+	})
+	return
+}
+
+func (h *Hash) value(vref int64) []byte {
+	l, n := binary.Uvarint(h.values.Bytes(vref, binary.MaxVarintLen64))
+	return h.values.Bytes(vref+int64(n), int(l))
+}
+
+// Values get room to read a whole uvarint back, however short.
+func valueAllocSize(value []byte) int {
+	return binary.MaxVarintLen64 + len(value)
+}
+
+func (h *Hash) putValue(value []byte) int64 {
+	var hdr [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(value)))
+	vref := h.values.Alloc(valueAllocSize(value))
+	buf := h.values.Bytes(vref, n+len(value))
+	copy(buf, hdr[:n])
+	copy(buf[n:], value)
+	return vref
+}
+
+func (h *Hash) Get(key []byte) (value []byte, ok bool) {
+	b, e := h.find(key, hash(key))
+	if e < 0 {
+		return nil, false
+	}
+	return h.value(int64(binary.LittleEndian.Uint64(b[e+entryValue:]))), true
+}
+
+func checkPut(key, value []byte) {
+	if len(key) == 0 || len(value) == 0 {
+		panic("Illegal nil key or value")
+	}
+	if len(key) > MaxKeySize {
+		panic(ErrKeyTooLarge)
+	}
+}
+
+func (h *Hash) put(key, value []byte, merge bool) (replaced bool) {
+	checkPut(key, value)
+	hk := hash(key)
+	b, e := h.find(key, hk)
+	if e >= 0 {
+		if merge {
+			value = append(h.value(int64(binary.LittleEndian.Uint64(b[e+entryValue:]))), value...)
+		}
+		// The old value stays where it is, since what Get returned
+		// may still point at it. It goes with the region on Dispose.
+		binary.LittleEndian.PutUint64(b[e+entryValue:], uint64(h.putValue(value)))
+		return true
+	}
+
+	size := entrySize(len(key))
+	for {
+		i := h.dir[hk&(1<<h.depth-1)]
+		b = h.buckets[i]
+		if used(b)+size <= bucketSize {
+			break
+		}
+		h.split(i, hk)
+	}
+
+	e = used(b)
+	binary.LittleEndian.PutUint64(b[e+entryHash:], hk)
+	binary.LittleEndian.PutUint64(b[e+entryValue:], uint64(h.putValue(value)))
+	binary.LittleEndian.PutUint16(b[e+entryKeyLen:], uint16(len(key)))
+	copy(b[e+entryKey:], key)
+	binary.LittleEndian.PutUint32(b[usedOffset:], uint32(e+size))
+	binary.LittleEndian.PutUint16(b[countOffset:], binary.LittleEndian.Uint16(b[countOffset:])+1)
+	h.size++
+	return false
+}
+
+// Split bucket i, which is full, on the next bit of the hash.
+func (h *Hash) split(i int32, hk uint64) {
+	b := h.buckets[i]
+	depth := uint(b[depthOffset])
+	if depth >= maxDepth {
+		panic(fmt.Sprint("exthash: too many keys with the same ", maxDepth, " hash bits"))
+	}
+	if depth == h.depth {
+		h.dir = append(h.dir, h.dir...)
+		h.depth++
+	}
+
+	// entries with the bit set move to a new bucket, the rest are
+	// packed again where they were
+	j := h.newBucket(depth + 1)
+	nb := h.buckets[j]
+	old := append([]byte{}, b[:used(b)]...)
+	b[depthOffset] = byte(depth + 1)
+	binary.LittleEndian.PutUint16(b[countOffset:], 0)
+	binary.LittleEndian.PutUint32(b[usedOffset:], headerSize)
+	eachEntry(old, func(e int) bool {
+		to := b
+		if binary.LittleEndian.Uint64(old[e+entryHash:])&(1<<depth) != 0 {
+			to = nb
+		}
+		size := entrySize(int(binary.LittleEndian.Uint16(old[e+entryKeyLen:])))
+		u := used(to)
+		copy(to[u:u+size], old[e:e+size])
+		binary.LittleEndian.PutUint32(to[usedOffset:], uint32(u+size))
+		binary.LittleEndian.PutUint16(to[countOffset:], binary.LittleEndian.Uint16(to[countOffset:])+1)
+		return true
+	})
+
+	for d := range h.dir {
+		if h.dir[d] == i && uint64(d)&(1<<depth) != 0 {
+			h.dir[d] = j
+		}
+	}
+}
+
+func (h *Hash) Put(key, value []byte) (replaced bool) {
+	return h.put(key, value, false)
+}
+
+func (h *Hash) Append(key, value []byte) {
+	h.put(key, value, true)
+}
+
+type emptyIter struct{}
+
+func (emptyIter) Next() (key []byte, value []byte, ok bool) {
+	return
+}
+
+// Finds nothing: a hash has no order to scan a prefix in. See Each.
+func (h *Hash) Start(prefix []byte) indexes.Iter {
+	return emptyIter{}
+}
+
+// Call f with every key and value, in no particular order. The slices
+// are only valid during the call.
+func (h *Hash) Each(f func(key, value []byte)) {
+	for _, b := range h.buckets {
+		eachEntry(b, func(e int) bool {
+			f(entryKeyBytes(b, e), h.value(int64(binary.LittleEndian.Uint64(b[e+entryValue:]))))
+			return true
+		})
+	}
+}
+
+func (h *Hash) Size() int64 {
+	return h.size
+}
+
+type HashStats struct {
+	Buckets     int
+	GlobalDepth int
+	// of the bytes in buckets
+	FillRate    float64
+	BucketBytes int64
+	ValueBytes  int64
+}
+
+func (h *Hash) Stats() HashStats {
+	usedBytes := 0
+	for _, b := range h.buckets {
+		usedBytes += used(b)
+	}
+	return HashStats{
+		Buckets:     len(h.buckets),
+		GlobalDepth: int(h.depth),
+		FillRate:    float64(usedBytes) / float64(len(h.buckets)*bucketSize),
+		BucketBytes: int64(len(h.buckets)) * bucketSize,
+		ValueBytes:  h.values.Size(),
+	}
+}
+
+// Check that every key is in the bucket its hash leads to, that the
+// directory agrees with the depths of the buckets, and that the
+// buckets hold Size keys.
+func (h *Hash) CheckConsistency() error {
+	count := int64(0)
+	for i, b := range h.buckets {
+		depth := uint(b[depthOffset])
+		if depth > h.depth {
+			return fmt.Errorf("bucket %d has depth %d, more than the directory's %d", i, depth, h.depth)
+		}
+		n := 0
+		var err error
+		eachEntry(b, func(e int) bool {
+			n++
+			hk := binary.LittleEndian.Uint64(b[e+entryHash:])
+			if hk != hash(entryKeyBytes(b, e)) {
+				err = fmt.Errorf("bucket %d: wrong hash for %v", i, entryKeyBytes(b, e))
+			} else if h.dir[hk&(1<<h.depth-1)] != int32(i) {
+				err = fmt.Errorf("bucket %d: %v belongs in bucket %d", i, entryKeyBytes(b, e), h.dir[hk&(1<<h.depth-1)])
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		if n != int(binary.LittleEndian.Uint16(b[countOffset:])) {
+			return fmt.Errorf("bucket %d has %d entries, says %d", i, n, binary.LittleEndian.Uint16(b[countOffset:]))
+		}
+		count += int64(n)
+	}
+	if count != h.size {
+		return fmt.Errorf("expected %d keys, found %d", h.size, count)
+	}
+
+	// a bucket of depth d has 2^(depth-d) directory entries
+	refs := make([]int, len(h.buckets))
+	for _, i := range h.dir {
+		refs[i]++
+	}
+	for i, b := range h.buckets {
+		if want := 1 << (h.depth - uint(b[depthOffset])); refs[i] != want {
+			return fmt.Errorf("bucket %d of depth %d has %d directory entries, expected %d", i, b[depthOffset], refs[i], want)
+		}
+	}
+	return nil
+}
+
+func (h *Hash) Dispose() {
+	for _, b := range h.buckets {
+		h.alloc.Free(b)
+	}
+	h.buckets = nil
+	h.values.Dispose()
+}
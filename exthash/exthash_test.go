@@ -0,0 +1,137 @@
+package exthash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/indexestest"
+	"github.com/avisagie/indexes/malloc"
+)
+
+var conformance = indexestest.Options{
+	Check: func(index indexes.ROIndex) error {
+		return index.(*Hash).CheckConsistency()
+	},
+	NoScan:     true,
+	MaxKeySize: MaxKeySize,
+}
+
+func TestConformance(t *testing.T) {
+	indexestest.Run(t, NewHash, conformance)
+}
+
+func FuzzHash(f *testing.F) {
+	indexestest.Fuzz(f, NewHash, conformance)
+}
+
+func TestSplits(t *testing.T) {
+	h := NewHash().(*Hash)
+	defer h.Dispose()
+	const n = 200000
+	for i := 0; i < n; i++ {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, uint64(i))
+		if h.Put(k, k) {
+			t.Fatal("Did not expect", k, "to be there")
+		}
+	}
+	if err := h.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+	stats := h.Stats()
+	t.Logf("%+v", stats)
+	if stats.Buckets < 2 || stats.FillRate < 0.4 {
+		t.Fatal("Expected the buckets to have split and be reasonably full:", stats)
+	}
+
+	seen := 0
+	h.Each(func(k, v []byte) {
+		if !bytes.Equal(k, v) {
+			t.Fatal("Expected", k, "got", v)
+		}
+		seen++
+	})
+	if seen != n || h.Size() != n {
+		t.Fatal("Expected", n, "keys, saw", seen, "size", h.Size())
+	}
+	if _, _, ok := h.Start(nil).Next(); ok {
+		t.Fatal("Expected Start to find nothing")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	b := btree.NewInMemoryBtree()
+	defer b.Dispose()
+	for i := 0; i < 10000; i++ {
+		k := []byte{byte(i >> 8), byte(i)}
+		b.Put(k, k)
+	}
+
+	h := Build(b.Start(nil), Options{ExpectedKeys: b.Size()})
+	defer h.Dispose()
+	if h.Size() != b.Size() {
+		t.Fatal("Expected", b.Size(), "keys, got", h.Size())
+	}
+	if buckets := h.Stats().Buckets; buckets != 1<<h.depth {
+		t.Fatal("Expected a directory sized up front not to split, got", buckets, "buckets for depth", h.depth)
+	}
+	for i := 0; i < 10000; i++ {
+		k := []byte{byte(i >> 8), byte(i)}
+		if v, ok := h.Get(k); !ok || !bytes.Equal(v, k) {
+			t.Fatal("Expected", k, "got", v)
+		}
+	}
+	if err := h.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDisposeFreesEverything(t *testing.T) {
+	alloc := malloc.NewDebug(malloc.Default)
+	index := NewHashOptions(Options{Allocator: alloc})
+	for i := 0; i < 100000; i++ {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, uint64(i)*7919)
+		index.Put(k, k)
+		index.Append(k, k)
+	}
+	index.Put([]byte{1}, make([]byte, 2*malloc.RegionChunkSize))
+
+	index.Dispose()
+	if count, bytes := alloc.Live(); count != 0 {
+		alloc.Report(os.Stderr)
+		t.Fatal("Leaked", count, "allocations,", bytes, "bytes")
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	index := NewHash()
+	defer index.Dispose()
+	k := make([]byte, 8)
+	for i := 0; i < 1000000; i++ {
+		binary.BigEndian.PutUint64(k, uint64(i)*0x9e3779b97f4a7c15)
+		index.Put(k, k)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint64(k, uint64(i%1000000)*0x9e3779b97f4a7c15)
+		if _, ok := index.Get(k); !ok {
+			b.Fatal("Expected", k)
+		}
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	index := NewHash()
+	defer index.Dispose()
+	k := make([]byte, 8)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint64(k, uint64(i)*0x9e3779b97f4a7c15)
+		index.Put(k, k)
+	}
+}
@@ -0,0 +1,155 @@
+package fst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrOrder = errors.New("fst: keys must be added in ascending order, without duplicates")
+
+type arc struct {
+	label  byte
+	output uint64
+	// address of the compiled node it leads to
+	target int64
+}
+
+// A node on the path of the last key added, which may still change.
+type pending struct {
+	arcs        []arc
+	final       bool
+	finalOutput uint64
+}
+
+// Builds an FST from keys added in ascending order, in the manner of
+// Mihov and Maurel and of Lucene. Nodes along the last key added stay
+// pending; once a key leaves a node's path behind, that suffix can no
+// longer change and is compiled, sharing any identical node compiled
+// before. Outputs are pushed as close to the root as they can go, so
+// that keys with a common prefix and similar outputs share arcs.
+type Builder struct {
+	buf      []byte
+	registry map[string]int64
+	frontier []*pending
+	prev     []byte
+	size     int64
+}
+
+func NewBuilder() *Builder {
+	// nothing is compiled at address 0, so no arc ever points there
+	return &Builder{
+		buf:      []byte{0},
+		registry: make(map[string]int64),
+		frontier: []*pending{{}},
+	}
+}
+
+// Add key with its output. Keys must ascend.
+func (b *Builder) Add(key []byte, output uint64) error {
+	if b.size > 0 && bytes.Compare(key, b.prev) <= 0 {
+		return ErrOrder
+	}
+
+	common := 0
+	for common < len(key) && common < len(b.prev) && key[common] == b.prev[common] {
+		common++
+	}
+	b.freeze(common)
+
+	for i := common; i < len(key); i++ {
+		b.frontier[i].arcs = append(b.frontier[i].arcs, arc{label: key[i]})
+		b.frontier = append(b.frontier, &pending{})
+	}
+	b.frontier[len(key)].final = true
+
+	// the shared prefix keeps the smaller output, and the rest moves
+	// down to every arc below it
+	for i := 0; i < common; i++ {
+		a := &b.frontier[i].arcs[len(b.frontier[i].arcs)-1]
+		if a.output <= output {
+			output -= a.output
+			continue
+		}
+		rest := a.output - output
+		a.output = output
+		output = 0
+		next := b.frontier[i+1]
+		for j := range next.arcs {
+			next.arcs[j].output += rest
+		}
+		if next.final {
+			next.finalOutput += rest
+		}
+	}
+	if common < len(key) {
+		b.frontier[common].arcs[len(b.frontier[common].arcs)-1].output = output
+	} else {
+		b.frontier[common].finalOutput = output
+	}
+
+	b.prev = append(b.prev[:0], key...)
+	b.size++
+	return nil
+}
+
+// Compile the pending nodes deeper than depth.
+func (b *Builder) freeze(depth int) {
+	for i := len(b.frontier) - 1; i > depth; i-- {
+		addr := b.compile(b.frontier[i])
+		parent := b.frontier[i-1]
+		parent.arcs[len(parent.arcs)-1].target = addr
+	}
+	b.frontier = b.frontier[:depth+1]
+}
+
+// Nodes are:
+//
+//	byte     1 if final, else 0
+//	uvarint  final output, if final
+//	uvarint  number of arcs
+//	         the label of each arc, ascending
+//	         for each arc a uvarint output, and a uvarint of this
+//	         node's address less the target's
+//
+// Children are compiled before their parents, so targets come first.
+func (b *Builder) compile(n *pending) int64 {
+	var tmp [binary.MaxVarintLen64]byte
+	var key []byte
+	if n.final {
+		key = append(key, 1)
+		key = append(key, tmp[:binary.PutUvarint(tmp[:], n.finalOutput)]...)
+	} else {
+		key = append(key, 0)
+	}
+	key = append(key, tmp[:binary.PutUvarint(tmp[:], uint64(len(n.arcs)))]...)
+	for _, a := range n.arcs {
+		key = append(key, a.label)
+	}
+	header := len(key)
+	for _, a := range n.arcs {
+		key = append(key, tmp[:binary.PutUvarint(tmp[:], a.output)]...)
+		key = append(key, tmp[:binary.PutUvarint(tmp[:], uint64(a.target))]...)
+	}
+	if addr, ok := b.registry[string(key)]; ok {
+		return addr
+	}
+
+	addr := int64(len(b.buf))
+	b.registry[string(key)] = addr
+	b.buf = append(b.buf, key[:header]...)
+	for _, a := range n.arcs {
+		b.buf = append(b.buf, tmp[:binary.PutUvarint(tmp[:], a.output)]...)
+		b.buf = append(b.buf, tmp[:binary.PutUvarint(tmp[:], uint64(addr-a.target))]...)
+	}
+	return addr
+}
+
+// Compile what is left and return the FST. The Builder is done with.
+func (b *Builder) Finish() *FST {
+	b.freeze(0)
+	root := b.compile(b.frontier[0])
+	f := &FST{buf: b.buf, root: root, size: b.size}
+	*b = Builder{}
+	return f
+}
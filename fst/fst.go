@@ -0,0 +1,161 @@
+// Finite state transducers: an ordered set of byte string keys, each
+// mapped to a uint64, in a form that shares common prefixes and
+// suffixes of the keys, as Lucene does for its term dictionaries.
+// Build one with a Builder from sorted keys. A Map pairs an FST with
+// a file of values, as a read-only indexes.ROIndex.
+package fst
+
+import (
+	"encoding/binary"
+)
+
+// Immutable once built, and safe for concurrent readers.
+type FST struct {
+	buf  []byte
+	root int64
+	size int64
+}
+
+// A node read out of buf.
+type node struct {
+	addr        int64
+	final       bool
+	finalOutput uint64
+	labels      []byte
+	// where the output and target of the first arc start
+	arcs int
+}
+
+func (f *FST) node(addr int64) (n node) {
+	n.addr = addr
+	p := int(addr)
+	n.final = f.buf[p] == 1
+	p++
+	if n.final {
+		o, l := binary.Uvarint(f.buf[p:])
+		n.finalOutput = o
+		p += l
+	}
+	count, l := binary.Uvarint(f.buf[p:])
+	p += l
+	n.labels = f.buf[p : p+int(count)]
+	n.arcs = p + int(count)
+	return
+}
+
+// The output and target of the arc whose output starts at p, and
+// where the next arc starts.
+func (f *FST) arc(n *node, p int) (output uint64, target int64, next int) {
+	output, l := binary.Uvarint(f.buf[p:])
+	p += l
+	delta, l := binary.Uvarint(f.buf[p:])
+	return output, n.addr - int64(delta), p + l
+}
+
+// Follow the arc labelled c out of n.
+func (f *FST) step(n *node, c byte) (output uint64, target int64, ok bool) {
+	i := 0
+	for ; i < len(n.labels) && n.labels[i] < c; i++ {
+	}
+	if i == len(n.labels) || n.labels[i] != c {
+		return 0, 0, false
+	}
+	p := n.arcs
+	for ; i > 0; i-- {
+		_, _, p = f.arc(n, p)
+	}
+	output, target, _ = f.arc(n, p)
+	return output, target, true
+}
+
+// The node reached by key, and the sum of the outputs on the way.
+func (f *FST) walk(key []byte) (n node, output uint64, ok bool) {
+	n = f.node(f.root)
+	for _, c := range key {
+		o, target, ok := f.step(&n, c)
+		if !ok {
+			return n, 0, false
+		}
+		output += o
+		n = f.node(target)
+	}
+	return n, output, true
+}
+
+func (f *FST) Get(key []byte) (output uint64, ok bool) {
+	n, output, ok := f.walk(key)
+	if !ok || !n.final {
+		return 0, false
+	}
+	return output + n.finalOutput, true
+}
+
+// Number of keys.
+func (f *FST) Size() int64 {
+	return f.size
+}
+
+// Bytes taken by the FST.
+func (f *FST) Bytes() int64 {
+	return int64(len(f.buf))
+}
+
+type frame struct {
+	n      node
+	i      int
+	p      int
+	output uint64
+}
+
+// Iterates keys in order, with their outputs.
+type Iterator struct {
+	f     *FST
+	stack []frame
+	key   []byte
+	// the node on top of the stack still has to be looked at as a
+	// key of its own
+	fresh bool
+}
+
+// Iterate over the keys that start with prefix, in order.
+func (f *FST) Start(prefix []byte) *Iterator {
+	it := &Iterator{f: f}
+	n, output, ok := f.walk(prefix)
+	if !ok {
+		return it
+	}
+	it.key = append(it.key, prefix...)
+	it.stack = append(it.stack, frame{n: n, p: n.arcs, output: output})
+	it.fresh = true
+	return it
+}
+
+// The next key and its output. The key is only valid until the next
+// call.
+func (it *Iterator) Next() (key []byte, output uint64, ok bool) {
+	for len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		if it.fresh {
+			it.fresh = false
+			if top.n.final {
+				return it.key, top.output + top.n.finalOutput, true
+			}
+		}
+		if top.i == len(top.n.labels) {
+			it.stack = it.stack[:len(it.stack)-1]
+			if len(it.stack) > 0 {
+				it.key = it.key[:len(it.key)-1]
+			}
+			continue
+		}
+
+		o, target, next := it.f.arc(&top.n, top.p)
+		it.key = append(it.key, top.n.labels[top.i])
+		top.i++
+		top.p = next
+		n := it.f.node(target)
+		it.stack = append(it.stack, frame{n: n, p: n.arcs, output: top.output + o})
+		it.fresh = true
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,255 @@
+package fst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/indexestest"
+)
+
+type kvIter struct {
+	kvs []indexestest.KV
+}
+
+func (it *kvIter) Next() (key []byte, value []byte, ok bool) {
+	if len(it.kvs) == 0 {
+		return
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv.Key, kv.Value, true
+}
+
+func TestConformance(t *testing.T) {
+	indexestest.RunRO(t, func(kvs []indexestest.KV) indexes.ROIndex {
+		m, err := Build(&kvIter{kvs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}, indexestest.Options{})
+}
+
+func TestOutputs(t *testing.T) {
+	// outputs that go up and down, so that they have to be moved
+	// around as keys are added
+	r := rand.New(rand.NewSource(1))
+	want := map[string]uint64{}
+	for len(want) < 20000 {
+		k := make([]byte, 1+r.Intn(8))
+		for i := range k {
+			k[i] = byte('a' + r.Intn(4))
+		}
+		want[string(k)] = uint64(r.Int63n(1 << uint(r.Intn(63))))
+	}
+	want[""] = 7
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := NewBuilder()
+	for _, k := range keys {
+		if err := b.Add([]byte(k), want[k]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := b.Finish()
+
+	if f.Size() != int64(len(keys)) {
+		t.Fatal("Expected", len(keys), "keys, got", f.Size())
+	}
+	for _, k := range keys {
+		if out, ok := f.Get([]byte(k)); !ok || out != want[k] {
+			t.Fatal("Expected", want[k], "for", k, "got", out, ok)
+		}
+	}
+	for _, k := range []string{"e", "ae", "aaaaaaaaa", "abcdabcde"} {
+		if _, ok := f.Get([]byte(k)); ok {
+			t.Fatal("Did not expect to find", k)
+		}
+	}
+
+	for _, prefix := range []string{"", "a", "ab", "dcb", "e"} {
+		it := f.Start([]byte(prefix))
+		i := sort.SearchStrings(keys, prefix)
+		for ; i < len(keys) && len(keys[i]) >= len(prefix) && keys[i][:len(prefix)] == prefix; i++ {
+			k, out, ok := it.Next()
+			if !ok || string(k) != keys[i] || out != want[keys[i]] {
+				t.Fatal("Expected", keys[i], want[keys[i]], "got", string(k), out, ok)
+			}
+		}
+		if k, _, ok := it.Next(); ok {
+			t.Fatal("Did not expect", string(k), "after prefix", prefix)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	b := NewBuilder()
+	if err := b.Add([]byte("b"), 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"b", "a", ""} {
+		if err := b.Add([]byte(k), 2); err != ErrOrder {
+			t.Fatal("Expected ErrOrder for", k, "got", err)
+		}
+	}
+	if err := b.Add([]byte("ba"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if out, ok := b.Finish().Get([]byte("ba")); !ok || out != 3 {
+		t.Fatal("Expected 3, got", out, ok)
+	}
+}
+
+// Keys with long shared prefixes and suffixes take far less room than
+// the keys themselves.
+func TestCompression(t *testing.T) {
+	index := btree.NewInMemoryBtree()
+	defer index.Dispose()
+	raw := 0
+	for host := 0; host < 50; host++ {
+		for path := 0; path < 1000; path++ {
+			k := []byte(fmt.Sprintf("https://host%02d.example.com/api/v1/items/%04d/details", host, path))
+			index.Put(k, []byte{1})
+			raw += len(k)
+		}
+	}
+
+	m, err := Build(index.Start(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fst, _ := m.Bytes()
+	t.Log(raw, "bytes of keys in", fst, "bytes")
+	if fst*20 > int64(raw) {
+		t.Fatal("Expected better than 20x compression, got", raw, "bytes in", fst)
+	}
+	if v, ok := m.Get([]byte("https://host07.example.com/api/v1/items/0123/details")); !ok || !bytes.Equal(v, []byte{1}) {
+		t.Fatal("Expected to find it, got", v, ok)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	index := btree.NewInMemoryBtree()
+	defer index.Dispose()
+	for i := 0; i < 10000; i++ {
+		k := []byte(fmt.Sprint("key", i))
+		index.Put(k, []byte(fmt.Sprint("value", i)))
+	}
+	m, err := Build(index.Start(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fst, values bytes.Buffer
+	if err := m.Write(&fst, &values); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadMap(&fst, &values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Size() != index.Size() {
+		t.Fatal("Expected", index.Size(), "keys, got", read.Size())
+	}
+	it, rit := index.Start(nil), read.Start(nil)
+	for {
+		k, v, ok := it.Next()
+		rk, rv, rok := rit.Next()
+		if ok != rok || !bytes.Equal(k, rk) || !bytes.Equal(v, rv) {
+			t.Fatal("Expected", string(k), string(v), ok, "got", string(rk), string(rv), rok)
+		}
+		if !ok {
+			break
+		}
+	}
+
+	if _, err := ReadMap(bytes.NewReader([]byte("not an fst")), &values); err != ErrFormat {
+		t.Fatal("Expected ErrFormat, got", err)
+	}
+}
+
+func TestReadCorrupt(t *testing.T) {
+	index := btree.NewInMemoryBtree()
+	defer index.Dispose()
+	for i := 0; i < 200; i++ {
+		index.Put([]byte(fmt.Sprint("key", i*7)), []byte(fmt.Sprint("value", i)))
+	}
+	m, _ := Build(index.Start(nil))
+	var fst, values bytes.Buffer
+	m.Write(&fst, &values)
+
+	// keys from the iterator are the caller's to keep
+	var held [][]byte
+	it := m.Start(nil)
+	for k, _, ok := it.Next(); ok; k, _, ok = it.Next() {
+		held = append(held, k)
+	}
+	for i, k := range held {
+		if i > 0 && bytes.Compare(held[i-1], k) >= 0 {
+			t.Fatal("Keys changed under the caller:", string(held[i-1]), string(k))
+		}
+	}
+
+	// a flipped byte is an error or still a map that works, never a
+	// panic
+	errs := 0
+	for i := len(Magic); i < fst.Len(); i++ {
+		for _, flip := range []byte{0x01, 0x80, 0xff} {
+			b := append([]byte{}, fst.Bytes()...)
+			b[i] ^= flip
+			read, err := ReadMap(bytes.NewReader(b), bytes.NewReader(values.Bytes()))
+			if err != nil {
+				errs++
+				continue
+			}
+			it := read.Start(nil)
+			for _, _, ok := it.Next(); ok; _, _, ok = it.Next() {
+			}
+		}
+	}
+	if errs == 0 {
+		t.Fatal("Expected some corruption to be noticed")
+	}
+
+	if _, err := ReadMap(bytes.NewReader(fst.Bytes()), bytes.NewReader(values.Bytes()[:values.Len()-1])); err == nil {
+		t.Fatal("Expected an error for truncated values")
+	}
+
+	// lengths from the file are not trusted with allocations
+	for _, length := range []uint64{1 << 62, 1 << 40, math.MaxUint64} {
+		b := []byte(Magic)
+		b = binary.AppendUvarint(b, 0)
+		b = binary.AppendUvarint(b, 1)
+		b = binary.AppendUvarint(b, length)
+		if _, err := ReadFST(bytes.NewReader(append(b, 1, 0, 0))); err == nil {
+			t.Fatal("Expected an error for", length, "bytes of nodes")
+		}
+	}
+
+	// values are only read as far as the FST points
+	endless := io.MultiReader(bytes.NewReader(values.Bytes()), zeros{})
+	if read, err := ReadMap(bytes.NewReader(fst.Bytes()), endless); err != nil || read.Size() != m.Size() {
+		t.Fatal("Expected the map back from endless values, got", err)
+	}
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,305 @@
+package fst
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/internal/bounded"
+)
+
+// An FST from keys to offsets in a file of values, each prefixed with
+// its length as a uvarint. Satisfies indexes.ROIndex.
+type Map struct {
+	fst    *FST
+	values []byte
+}
+
+// Build a Map from the keys and values from it, which must be in
+// order, as from another index's Start(nil).
+func Build(it indexes.Iter) (*Map, error) {
+	b := NewBuilder()
+	var values []byte
+	var hdr [binary.MaxVarintLen64]byte
+	for {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		if err := b.Add(k, uint64(len(values))); err != nil {
+			return nil, err
+		}
+		values = append(values, hdr[:binary.PutUvarint(hdr[:], uint64(len(v)))]...)
+		values = append(values, v...)
+	}
+	return &Map{b.Finish(), values}, nil
+}
+
+func (m *Map) FST() *FST {
+	return m.fst
+}
+
+func (m *Map) value(offset uint64) []byte {
+	l, n := binary.Uvarint(m.values[offset:])
+	s := int(offset) + n
+	e := s + int(l)
+	return m.values[s:e:e]
+}
+
+func (m *Map) Get(key []byte) (value []byte, ok bool) {
+	offset, ok := m.fst.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return m.value(offset), true
+}
+
+type mapIter struct {
+	m  *Map
+	it *Iterator
+}
+
+// Keys are copies, since the Iterator reuses its key for the next
+// one.
+func (i mapIter) Next() (key []byte, value []byte, ok bool) {
+	key, offset, ok := i.it.Next()
+	if !ok {
+		return nil, nil, false
+	}
+	return append([]byte(nil), key...), i.m.value(offset), true
+}
+
+func (m *Map) Start(prefix []byte) indexes.Iter {
+	return mapIter{m, m.fst.Start(prefix)}
+}
+
+func (m *Map) Size() int64 {
+	return m.fst.Size()
+}
+
+// Bytes taken by the FST and the values.
+func (m *Map) Bytes() (fst, values int64) {
+	return m.fst.Bytes(), int64(len(m.values))
+}
+
+// Everything is on the Go heap, so there is nothing to free.
+func (m *Map) Dispose() {
+	m.fst, m.values = nil, nil
+}
+
+const Magic = "indexes fst 1\n"
+
+var ErrFormat = errors.New("fst: not an FST file")
+
+// Write the FST as: Magic, then the root's address, the number of
+// keys and the number of bytes that follow as uvarints, then the
+// nodes.
+func (f *FST) WriteTo(out io.Writer) (n int64, err error) {
+	w := bufio.NewWriter(out)
+	hdr := []byte(Magic)
+	var tmp [binary.MaxVarintLen64]byte
+	for _, x := range []int64{f.root, f.size, int64(len(f.buf))} {
+		hdr = append(hdr, tmp[:binary.PutUvarint(tmp[:], uint64(x))]...)
+	}
+	if _, err = w.Write(hdr); err != nil {
+		return
+	}
+	if _, err = w.Write(f.buf); err != nil {
+		return
+	}
+	return int64(len(hdr) + len(f.buf)), w.Flush()
+}
+
+// Read an FST written by WriteTo. Every node is checked, so that a
+// corrupt file is an error here rather than a panic later.
+func ReadFST(in io.Reader) (*FST, error) {
+	r := bufio.NewReader(in)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != Magic {
+		return nil, ErrFormat
+	}
+	var hdr [3]uint64
+	for i := range hdr {
+		x, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("fst: reading header: %v", err)
+		}
+		hdr[i] = x
+	}
+	if hdr[0] >= hdr[2] || hdr[1] > math.MaxInt64 || hdr[2] > math.MaxInt {
+		return nil, ErrFormat
+	}
+	buf, err := bounded.ReadFull(r, nil, int(hdr[2]))
+	if err != nil {
+		return nil, fmt.Errorf("fst: reading nodes: %v", err)
+	}
+	f := &FST{buf: buf, root: int64(hdr[0]), size: int64(hdr[1])}
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Check that the nodes reachable from the root are well formed, that
+// arcs only point back, and that they lead to size keys.
+func (f *FST) check() error {
+	// keys at or below each node checked so far
+	keys := make(map[int64]uint64)
+	var below func(addr int64) (uint64, error)
+	below = func(addr int64) (uint64, error) {
+		if k, ok := keys[addr]; ok {
+			return k, nil
+		}
+		if !f.checkNode(addr) {
+			return 0, ErrFormat
+		}
+		n := f.node(addr)
+		k := uint64(0)
+		if n.final {
+			k = 1
+		}
+		p := n.arcs
+		for range n.labels {
+			var target int64
+			_, target, p = f.arc(&n, p)
+			kt, err := below(target)
+			if err != nil {
+				return 0, err
+			}
+			if k += kt; k > uint64(f.size) {
+				return 0, ErrFormat
+			}
+		}
+		keys[addr] = k
+		return k, nil
+	}
+	k, err := below(f.root)
+	if err != nil {
+		return err
+	}
+	if k != uint64(f.size) {
+		return ErrFormat
+	}
+	return nil
+}
+
+// Whether the node at addr can be read without going past the end,
+// with its labels ascending and its arcs pointing back.
+func (f *FST) checkNode(addr int64) bool {
+	if addr < 0 || addr >= int64(len(f.buf)) || f.buf[addr] > 1 {
+		return false
+	}
+	p := int(addr) + 1
+	uvarint := func() (uint64, bool) {
+		x, l := binary.Uvarint(f.buf[p:])
+		p += l
+		return x, l > 0
+	}
+	if f.buf[addr] == 1 {
+		if _, ok := uvarint(); !ok {
+			return false
+		}
+	}
+	count, ok := uvarint()
+	if !ok || count > uint64(len(f.buf)-p) {
+		return false
+	}
+	labels := f.buf[p : p+int(count)]
+	for i := 1; i < len(labels); i++ {
+		if labels[i] <= labels[i-1] {
+			return false
+		}
+	}
+	p += int(count)
+	for range labels {
+		_, ok1 := uvarint()
+		delta, ok2 := uvarint()
+		if !ok1 || !ok2 || delta == 0 || delta > uint64(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// Write the FST to fst and the values to values.
+func (m *Map) Write(fst, values io.Writer) error {
+	if _, err := m.fst.WriteTo(fst); err != nil {
+		return err
+	}
+	_, err := values.Write(m.values)
+	return err
+}
+
+// Read a Map written by Write.
+func ReadMap(fst, values io.Reader) (*Map, error) {
+	f, err := ReadFST(fst)
+	if err != nil {
+		return nil, err
+	}
+	v, err := readValues(values, f)
+	if err != nil {
+		return nil, err
+	}
+	m := &Map{f, v}
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Read as much of values as f points into. Build writes the values in
+// key order, so the one with the largest offset ends the file, and
+// nothing more is read than it says is there.
+func readValues(values io.Reader, f *FST) ([]byte, error) {
+	last, any := uint64(0), false
+	it := f.Start(nil)
+	for _, offset, ok := it.Next(); ok; _, offset, ok = it.Next() {
+		if offset > last {
+			last = offset
+		}
+		any = true
+	}
+	if !any {
+		return nil, nil
+	}
+	if last > math.MaxInt {
+		return nil, ErrFormat
+	}
+	r := bufio.NewReader(values)
+	v, err := bounded.ReadFull(r, nil, int(last))
+	if err != nil {
+		return nil, fmt.Errorf("fst: reading values: %v", err)
+	}
+	l, err := binary.ReadUvarint(r)
+	if err != nil || l > math.MaxInt {
+		return nil, ErrFormat
+	}
+	v = binary.AppendUvarint(v, l)
+	tail, err := bounded.ReadFull(r, nil, int(l))
+	if err != nil {
+		return nil, fmt.Errorf("fst: reading values: %v", err)
+	}
+	return append(v, tail...), nil
+}
+
+// Check that every key's output is the offset of a value in values.
+func (m *Map) check() error {
+	it := m.fst.Start(nil)
+	for {
+		_, offset, ok := it.Next()
+		if !ok {
+			return nil
+		}
+		if offset >= uint64(len(m.values)) {
+			return ErrFormat
+		}
+		l, n := binary.Uvarint(m.values[offset:])
+		if n <= 0 || l > uint64(len(m.values))-offset-uint64(n) {
+			return ErrFormat
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Command indexbench loads keys into an index and then runs a mix of
+// gets, puts and scans against it. It reports throughput, latency
+// percentiles and memory use as JSON, so that runs can be compared
+// across commits:
+//
+//	indexbench -backend btree -workload zipfian -n 1000000 -mix get=90,put=10 -label $(git rev-parse --short HEAD)
+//
+// Workloads are seq (increasing keys), random, zipfian (random keys,
+// some of them hot) and timeseries (series id then time, recent
+// points read most).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"time"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/art"
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/exthash"
+	"github.com/avisagie/indexes/skiplist"
+)
+
+var backends = map[string]func() indexes.Index{
+	"art":      art.NewTree,
+	"btree":    btree.NewInMemoryBtree,
+	"exthash":  exthash.NewHash,
+	"skiplist": skiplist.NewSkiplist,
+}
+
+// Longest key each backend takes, where it has a limit.
+var maxKeySizes = map[string]int{
+	"btree":    btree.MaxKeySize,
+	"exthash":  exthash.MaxKeySize,
+	"skiplist": skiplist.MaxKeySize,
+}
+
+type config struct {
+	Label      string
+	Backend    string
+	Workload   string
+	Keys       uint64
+	Ops        int64
+	KeySize    int
+	ValueSize  int
+	Mix        map[string]int
+	ScanLength int
+	ScanPrefix int
+	PutNext    bool
+	Seed       int64
+}
+
+type latency struct {
+	Count                     int64
+	Mean, P50, P90, P99, P999 int64
+	Max                       int64
+}
+
+type phase struct {
+	Name      string
+	Ops       int64
+	Seconds   float64
+	OpsPerSec float64
+	// in nanoseconds, by op
+	Latency map[string]latency
+	// gets that did not find their key
+	Misses int64 `json:",omitempty"`
+	// keys read by scans
+	ScannedKeys int64 `json:",omitempty"`
+}
+
+type memory struct {
+	HeapAlloc uint64
+	Sys       uint64
+	Index     *btree.MemoryUsage `json:",omitempty"`
+}
+
+type report struct {
+	Config     config
+	GoVersion  string
+	Start      time.Time
+	Phases     []phase
+	AfterLoad  memory
+	AfterRun   memory
+	Consistent *bool `json:",omitempty"`
+}
+
+func main() {
+	var c config
+	mixArg := flag.String("mix", "get=90,put=10", "percentages of get, put and scan ops")
+	flag.StringVar(&c.Label, "label", "", "label for the report, like a commit")
+	flag.StringVar(&c.Backend, "backend", "btree", "index to run against")
+	flag.StringVar(&c.Workload, "workload", "random", "seq, random, zipfian or timeseries")
+	flag.Uint64Var(&c.Keys, "n", 1000000, "number of keys to load")
+	flag.Int64Var(&c.Ops, "ops", 1000000, "number of ops after loading")
+	flag.IntVar(&c.KeySize, "keysize", 16, "bytes per key, at least 10 and at most the backend's MaxKeySize")
+	flag.IntVar(&c.ValueSize, "valuesize", 16, "bytes per value, at least 1")
+	flag.IntVar(&c.ScanLength, "scanlen", 100, "keys read per scan")
+	flag.IntVar(&c.ScanPrefix, "scanprefix", 6, "bytes of a key that a scan uses as prefix")
+	flag.BoolVar(&c.PutNext, "putnext", false, "load with PutNext, for the seq workload")
+	flag.Int64Var(&c.Seed, "seed", 1, "random seed")
+	out := flag.String("o", "", "write the report here instead of stdout")
+	cpuprofile := flag.String("cpuprofile", "", "write a CPU profile of the run here")
+	check := flag.Bool("check", false, "check the index's consistency at the end, if it can")
+	flag.Parse()
+
+	if err := run(c, *mixArg, *out, *cpuprofile, *check); err != nil {
+		fmt.Fprintln(os.Stderr, "indexbench:", err)
+		os.Exit(1)
+	}
+}
+
+func run(c config, mixArg, out, cpuprofile string, check bool) error {
+	m, err := parseMix(mixArg)
+	if err != nil {
+		return err
+	}
+	c.Mix = make(map[string]int)
+	for o, name := range opNames {
+		c.Mix[name] = m[o]
+	}
+	newIndex, ok := backends[c.Backend]
+	if !ok {
+		return fmt.Errorf("unknown backend %q", c.Backend)
+	}
+	newWorkload, ok := workloads[c.Workload]
+	if !ok {
+		return fmt.Errorf("unknown workload %q", c.Workload)
+	}
+	if c.KeySize < 10 || c.ValueSize < 1 || c.Keys < 2 {
+		return fmt.Errorf("need -keysize >= 10, -valuesize >= 1 and -n >= 2")
+	}
+	if max, ok := maxKeySizes[c.Backend]; ok && c.KeySize > max {
+		return fmt.Errorf("need -keysize <= %d for %s", max, c.Backend)
+	}
+	if c.ScanPrefix < 0 || c.ScanPrefix > c.KeySize {
+		return fmt.Errorf("need 0 <= -scanprefix <= -keysize")
+	}
+	if c.PutNext && c.Workload != "seq" {
+		return fmt.Errorf("-putnext needs keys in order, which only the seq workload has")
+	}
+
+	if cpuprofile != "" {
+		f, err := os.Create(cpuprofile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+
+	r := rand.New(rand.NewSource(c.Seed))
+	w := newWorkload(r, c.Keys)
+	index := newIndex()
+	defer index.Dispose()
+
+	rep := report{Config: c, GoVersion: runtime.Version(), Start: time.Now()}
+
+	load, err := runLoad(c, w, index)
+	if err != nil {
+		return err
+	}
+	rep.Phases = append(rep.Phases, load)
+	rep.AfterLoad = memoryOf(index)
+
+	rep.Phases = append(rep.Phases, runMixed(c, m, w, r, index))
+	rep.AfterRun = memoryOf(index)
+
+	if check {
+		if c, ok := index.(interface{ CheckConsistency() error }); ok {
+			err := c.CheckConsistency()
+			consistent := err == nil
+			rep.Consistent = &consistent
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "indexbench: inconsistent:", err)
+			}
+		}
+	}
+
+	s, err := json.MarshalIndent(rep, "", "\t")
+	if err != nil {
+		return err
+	}
+	s = append(s, '\n')
+	if out == "" {
+		_, err = os.Stdout.Write(s)
+		return err
+	}
+	return os.WriteFile(out, s, 0666)
+}
+
+func runLoad(c config, w workload, index indexes.Index) (phase, error) {
+	lat := newLatencies()
+	var putNext func(key, value []byte)
+	if c.PutNext {
+		in, ok := index.(indexes.PutableInOrder)
+		if !ok {
+			return phase{}, fmt.Errorf("%s cannot PutNext", c.Backend)
+		}
+		putNext = in.PutNext
+	}
+
+	start := time.Now()
+	for i := uint64(0); i < c.Keys; i++ {
+		k, v := w.key(i, c.KeySize), value(i, c.ValueSize)
+		t := time.Now()
+		if putNext != nil {
+			putNext(k, v)
+		} else {
+			index.Put(k, v)
+		}
+		lat.add(opPut, time.Since(t))
+	}
+	return lat.phase("load", time.Since(start)), nil
+}
+
+func runMixed(c config, m opMix, w workload, r *rand.Rand, index indexes.Index) phase {
+	lat := newLatencies()
+	loaded := c.Keys
+	var misses, scanned int64
+
+	start := time.Now()
+	for j := int64(0); j < c.Ops; j++ {
+		switch o := m.pick(r); o {
+		case opGet:
+			k := w.key(w.pick(r, loaded), c.KeySize)
+			t := time.Now()
+			_, ok := index.Get(k)
+			lat.add(o, time.Since(t))
+			if !ok {
+				misses++
+			}
+		case opPut:
+			i := loaded
+			if !w.appends() {
+				i = w.pick(r, loaded)
+			}
+			k, v := w.key(i, c.KeySize), value(i+uint64(j), c.ValueSize)
+			t := time.Now()
+			index.Put(k, v)
+			lat.add(o, time.Since(t))
+			if i == loaded {
+				loaded++
+			}
+		case opScan:
+			prefix := w.key(w.pick(r, loaded), c.KeySize)[:c.ScanPrefix]
+			t := time.Now()
+			it := index.Start(prefix)
+			for n := 0; n < c.ScanLength; n++ {
+				if _, _, ok := it.Next(); !ok {
+					break
+				}
+				scanned++
+			}
+			lat.add(o, time.Since(t))
+		}
+	}
+	p := lat.phase("mixed", time.Since(start))
+	p.Misses, p.ScannedKeys = misses, scanned
+	return p
+}
+
+type latencies [numOps][]int64
+
+func newLatencies() *latencies {
+	return &latencies{}
+}
+
+func (l *latencies) add(o op, d time.Duration) {
+	l[o] = append(l[o], int64(d))
+}
+
+func (l *latencies) phase(name string, elapsed time.Duration) phase {
+	p := phase{Name: name, Seconds: elapsed.Seconds(), Latency: make(map[string]latency)}
+	for o, ns := range l {
+		if len(ns) == 0 {
+			continue
+		}
+		p.Ops += int64(len(ns))
+		sort.Slice(ns, func(i, j int) bool { return ns[i] < ns[j] })
+		sum := int64(0)
+		for _, n := range ns {
+			sum += n
+		}
+		at := func(q float64) int64 { return ns[int(q*float64(len(ns)-1))] }
+		p.Latency[opNames[o]] = latency{
+			Count: int64(len(ns)),
+			Mean:  sum / int64(len(ns)),
+			P50:   at(0.5),
+			P90:   at(0.9),
+			P99:   at(0.99),
+			P999:  at(0.999),
+			Max:   ns[len(ns)-1],
+		}
+	}
+	if p.Seconds > 0 {
+		p.OpsPerSec = float64(p.Ops) / p.Seconds
+	}
+	return p
+}
+
+func memoryOf(index indexes.Index) (m memory) {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	m.HeapAlloc, m.Sys = stats.HeapAlloc, stats.Sys
+	if b, ok := index.(interface{ MemoryUsage() btree.MemoryUsage }); ok {
+		usage := b.MemoryUsage()
+		m.Index = &usage
+	}
+	return
+}
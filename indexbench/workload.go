@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// The shape of the keys. Key i is a function of i alone, so that any
+// key can be made again to look it up.
+type workload interface {
+	// The i-th key loaded, in load order.
+	key(i uint64, size int) []byte
+	// The index of a loaded key to get or scan, out of n.
+	pick(r *rand.Rand, n uint64) uint64
+	// Whether puts in the mixed phase add keys after the loaded
+	// ones. Otherwise they overwrite loaded keys.
+	appends() bool
+}
+
+var workloads = map[string]func(r *rand.Rand, n uint64) workload{
+	"seq":        func(*rand.Rand, uint64) workload { return sequential{} },
+	"random":     func(*rand.Rand, uint64) workload { return random{} },
+	"zipfian":    newZipfian,
+	"timeseries": func(*rand.Rand, uint64) workload { return timeseries{} },
+}
+
+// Fill the rest of a key after its first bytes, so that keys are as
+// long as asked but still ordered by their first bytes.
+func pad(k []byte, i uint64, size int) []byte {
+	for len(k) < size {
+		i = mix(i)
+		k = append(k, byte(i))
+	}
+	return k[:size]
+}
+
+// A bijection on uint64 that scatters consecutive numbers (the
+// splitmix64 finalizer).
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func bigEndian(x uint64) []byte {
+	k := make([]byte, 8, 16)
+	binary.BigEndian.PutUint64(k, x)
+	return k
+}
+
+// Keys in increasing order.
+type sequential struct{}
+
+func (sequential) key(i uint64, size int) []byte      { return pad(bigEndian(i), i, size) }
+func (sequential) pick(r *rand.Rand, n uint64) uint64 { return uint64(r.Int63n(int64(n))) }
+func (sequential) appends() bool                      { return true }
+
+// Keys in random order, read uniformly.
+type random struct{}
+
+func (random) key(i uint64, size int) []byte      { return pad(bigEndian(mix(i)), i, size) }
+func (random) pick(r *rand.Rand, n uint64) uint64 { return uint64(r.Int63n(int64(n))) }
+func (random) appends() bool                      { return true }
+
+// Keys in random order, read and written with a zipfian distribution:
+// a few keys are very hot.
+type zipfian struct {
+	random
+	z *rand.Zipf
+}
+
+func newZipfian(r *rand.Rand, n uint64) workload {
+	return zipfian{z: rand.NewZipf(r, 1.1, 1, n-1)}
+}
+
+func (z zipfian) pick(r *rand.Rand, n uint64) uint64 { return z.z.Uint64() }
+func (zipfian) appends() bool                        { return false }
+
+// Points of many series in time order: the key is the series and then
+// the time, and every series gets a point at every tick. Recent points
+// are read most.
+type timeseries struct{}
+
+const numSeries = 1000
+
+func (timeseries) key(i uint64, size int) []byte {
+	series, tick := i%numSeries, i/numSeries
+	k := make([]byte, 10, 16)
+	binary.BigEndian.PutUint16(k, uint16(series))
+	binary.BigEndian.PutUint64(k[2:], 1400000000000+tick*1000)
+	return pad(k, i, size)
+}
+
+func (timeseries) pick(r *rand.Rand, n uint64) uint64 {
+	// exponentially fewer reads further back in time
+	back := uint64(r.ExpFloat64() * float64(n) / 20)
+	if back >= n {
+		back = n - 1
+	}
+	return n - 1 - back
+}
+
+func (timeseries) appends() bool { return true }
+
+func value(i uint64, size int) []byte {
+	v := make([]byte, size)
+	for j := range v {
+		i = mix(i)
+		v[j] = byte(i)
+	}
+	return v
+}
+
+type op int
+
+const (
+	opGet op = iota
+	opPut
+	opScan
+	numOps
+)
+
+var opNames = [numOps]string{"get", "put", "scan"}
+
+// Percentages of each op, like "get=80,put=15,scan=5".
+type opMix [numOps]int
+
+func parseMix(s string) (m opMix, err error) {
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return m, fmt.Errorf("bad op mix %q, expected op=percent,...", s)
+		}
+		o, found := 0, false
+		for i, name := range opNames {
+			if name == kv[0] {
+				o, found = i, true
+			}
+		}
+		if !found {
+			return m, fmt.Errorf("unknown op %q in %q", kv[0], s)
+		}
+		if m[o], err = strconv.Atoi(kv[1]); err != nil || m[o] < 0 {
+			return m, fmt.Errorf("bad percentage for %s in %q", kv[0], s)
+		}
+		total += m[o]
+	}
+	if total != 100 {
+		return m, fmt.Errorf("op mix %q adds up to %d, not 100", s, total)
+	}
+	return m, nil
+}
+
+// Pick an op according to the mix.
+func (m opMix) pick(r *rand.Rand) op {
+	x := r.Intn(100)
+	for o := op(0); o < numOps; o++ {
+		if x < m[o] {
+			return o
+		}
+		x -= m[o]
+	}
+	return opGet
+}
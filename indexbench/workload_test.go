@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/exthash"
+)
+
+func TestParseMix(t *testing.T) {
+	m, err := parseMix("get=70,scan=10,put=20")
+	if err != nil || m != (opMix{70, 20, 10}) {
+		t.Fatal(m, err)
+	}
+	for _, bad := range []string{"get=70", "get=50,delete=50", "get", "get=x,put=100", "get=-10,put=110"} {
+		if _, err := parseMix(bad); err == nil {
+			t.Error("expected an error for", bad)
+		}
+	}
+}
+
+func TestWorkloads(t *testing.T) {
+	const n = 20000
+	r := rand.New(rand.NewSource(1))
+	for name, newWorkload := range workloads {
+		w := newWorkload(r, n)
+		seen := make(map[string]bool)
+		for i := uint64(0); i < n; i++ {
+			k := w.key(i, 20)
+			if len(k) != 20 {
+				t.Fatal(name, "expected 20 bytes, got", len(k))
+			}
+			if !bytes.Equal(k, w.key(i, 20)) {
+				t.Fatal(name, "expected the same key for", i)
+			}
+			if seen[string(k)] {
+				t.Fatal(name, "duplicate key", k)
+			}
+			seen[string(k)] = true
+			if p := w.pick(r, n); p >= n {
+				t.Fatal(name, "picked", p, "of", n)
+			}
+		}
+	}
+
+	for i := uint64(1); i < n; i++ {
+		if bytes.Compare(sequential{}.key(i-1, 10), sequential{}.key(i, 10)) >= 0 {
+			t.Fatal("sequential keys out of order at", i)
+		}
+		if i >= numSeries && bytes.Compare(timeseries{}.key(i-numSeries, 12), timeseries{}.key(i, 12)) >= 0 {
+			t.Fatal("series out of order at", i)
+		}
+	}
+}
+
+func TestRunChecks(t *testing.T) {
+	ok := config{Backend: "btree", Workload: "seq", Keys: 100, KeySize: 16, ValueSize: 16, ScanPrefix: 6}
+	for _, bad := range []func(c *config){
+		func(c *config) { c.ScanPrefix = 17 },
+		func(c *config) { c.ScanPrefix = -1 },
+		func(c *config) { c.PutNext, c.Workload = true, "random" },
+		func(c *config) { c.PutNext, c.Workload = true, "timeseries" },
+		func(c *config) { c.KeySize = btree.MaxKeySize + 1 },
+		func(c *config) { c.Backend, c.KeySize = "exthash", exthash.MaxKeySize+1 },
+	} {
+		c := ok
+		bad(&c)
+		if err := run(c, "get=100", "", "", false); err == nil {
+			t.Error("expected an error for", c)
+		}
+	}
+}
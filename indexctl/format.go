@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// How keys and values are written on the command line and in output.
+type format interface {
+	// Parse a key or value given on the command line or in TSV
+	// input.
+	parse(s string) ([]byte, error)
+	format(b []byte) string
+}
+
+var formats = map[string]format{
+	"hex":     hexFormat{},
+	"escaped": escapedFormat{},
+	"decoded": decodedFormat{},
+}
+
+func getFormat(name string) (format, error) {
+	f, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q, expected hex, escaped or decoded", name)
+	}
+	return f, nil
+}
+
+type hexFormat struct{}
+
+func (hexFormat) parse(s string) ([]byte, error) {
+	return hex.DecodeString(s)
+}
+
+func (hexFormat) format(b []byte) string {
+	return hex.EncodeToString(b)
+}
+
+// Go string literal escapes, without the quotes: \t, \xff and so on.
+type escapedFormat struct{}
+
+func (escapedFormat) parse(s string) ([]byte, error) {
+	u, err := strconv.Unquote(`"` + s + `"`)
+	if err != nil {
+		return nil, fmt.Errorf("bad escapes in %q", s)
+	}
+	return []byte(u), nil
+}
+
+func (escapedFormat) format(b []byte) string {
+	q := strconv.Quote(string(b))
+	return q[1 : len(q)-1]
+}
+
+// Text as is. On output, keys that are not printable text are shown
+// as what they most likely are: 8 bytes as a big endian number,
+// anything else escaped. Those do not parse back, since "256" could
+// as well be text: decoded is for reading, hex and escaped for keys
+// that have to go back in.
+type decodedFormat struct{}
+
+func (decodedFormat) parse(s string) ([]byte, error) {
+	return []byte(s), nil
+}
+
+func (decodedFormat) format(b []byte) string {
+	if printable(b) {
+		return string(b)
+	}
+	if len(b) == 8 {
+		return strconv.FormatUint(binary.BigEndian.Uint64(b), 10)
+	}
+	return escapedFormat{}.format(b)
+}
+
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !strconv.IsPrint(r) || r == '\t' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	keys := [][]byte{
+		[]byte("web1"),
+		{0, 0, 0, 0, 0, 0, 1, 0},
+		[]byte("tab\there"),
+		{0xff, 0xfe, 'x'},
+		[]byte("ünïcode"),
+	}
+	for name, f := range formats {
+		for _, k := range keys {
+			s := f.format(k)
+			if name == "decoded" && !printable(k) {
+				// not meant to go back
+				continue
+			}
+			got, err := f.parse(s)
+			if err != nil || !bytes.Equal(got, k) {
+				t.Error(name, "expected", k, "back from", s, "got", got, err)
+			}
+		}
+	}
+
+	if s := (decodedFormat{}).format([]byte{0, 0, 0, 0, 0, 0, 1, 0}); s != "256" {
+		t.Error("expected 8 bytes to decode as a number, got", s)
+	}
+	if s := (escapedFormat{}).format([]byte("a\tb\x00")); s != `a\tb\x00` {
+		t.Error("expected escapes, got", s)
+	}
+}
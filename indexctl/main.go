@@ -0,0 +1,416 @@
+// Command indexctl builds index files and looks inside them. Index
+// files are those written by the indexer package: keys and values
+// in key order, each prefixed with its length as a uvarint.
+//
+//	indexctl build [-sorted] [-in tsv|lp|dump|jsonl] [-format f] out.idx [input]
+//	indexctl get [-format f] index.idx key
+//	indexctl scan [-format f] [-prefix p | -start s -end e] [-n max] index.idx
+//	indexctl stats index.idx
+//	indexctl check index.idx
+//	indexctl dump index.idx
+//	indexctl export [-json] index.idx [out]
+//
+// Keys and values on the command line, in TSV input and in output are
+// in the -format given: hex, escaped (Go string escapes) or decoded
+// (text as is, with binary keys shown as numbers where they look like
+// them). decoded is for reading output: on the command line and in
+// input it takes text as is, so give binary keys in hex or escaped.
+// Output is a key and value per line, separated by a tab.
+//
+// export writes the format of the dump package, which build reads
+// back with -in dump, or -in jsonl with -json.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/dump"
+	"github.com/avisagie/indexes/indexer"
+)
+
+var commands = map[string]func(args []string) error{
+	"build":  build,
+	"get":    get,
+	"scan":   scan,
+	"stats":  stats,
+	"check":  check,
+	"dump":   dumpTree,
+	"export": export,
+}
+
+var usages = map[string]string{
+	"build":  "build [-sorted] [-in tsv|lp|dump|jsonl] [-format f] out.idx [input]",
+	"get":    "get [-format f] index.idx key",
+	"scan":   "scan [-format f] [-prefix p | -start s -end e] [-n max] index.idx",
+	"stats":  "stats index.idx",
+	"check":  "check index.idx",
+	"dump":   "dump index.idx",
+	"export": "export [-json] index.idx [out]",
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	for _, name := range []string{"build", "get", "scan", "stats", "check", "dump", "export"} {
+		fmt.Fprintln(os.Stderr, "  indexctl", usages[name])
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "indexctl", os.Args[1]+":", err)
+		os.Exit(1)
+	}
+}
+
+// Flags for a command, and a -format flag if withFormat.
+func flags(name string, withFormat bool) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: indexctl", usages[name])
+		fs.PrintDefaults()
+	}
+	var f *string
+	if withFormat {
+		f = fs.String("format", "escaped", "format of keys and values: hex, escaped or decoded (display only for binary keys)")
+	}
+	return fs, f
+}
+
+// Parse args, and check that there are n positional arguments left,
+// or n and one more if optional.
+func parse(fs *flag.FlagSet, args []string, n int, optional bool) error {
+	fs.Parse(args)
+	if fs.NArg() == n || optional && fs.NArg() == n+1 {
+		return nil
+	}
+	fs.Usage()
+	return fmt.Errorf("expected %d arguments, got %d", n, fs.NArg())
+}
+
+func build(args []string) error {
+	fs, formatName := flags("build", true)
+	sorted := fs.Bool("sorted", false, "input is in key order, which builds faster")
+	in := fs.String("in", "tsv", "input format: tsv (key, tab, value per line), lp (as index files), dump or jsonl (from export)")
+	if err := parse(fs, args, 1, true); err != nil {
+		return err
+	}
+	f, err := getFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	input := io.Reader(os.Stdin)
+	if fs.NArg() == 2 {
+		file, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	index := btree.NewInMemoryBtree().(*btree.Btree)
+	defer index.Dispose()
+
+	var prev []byte
+	var putErr error
+	put := func(key, value []byte) {
+		if putErr != nil {
+			return
+		}
+		if len(key) == 0 || len(value) == 0 {
+			putErr = fmt.Errorf("empty key or value for key %q", key)
+			return
+		}
+		if err := btree.CheckKey(key); err != nil {
+			putErr = err
+			return
+		}
+		if !*sorted {
+			index.Put(key, value)
+			return
+		}
+		if prev != nil && bytes.Compare(key, prev) <= 0 {
+			putErr = fmt.Errorf("input is not sorted: %s after %s", f.format(key), f.format(prev))
+			return
+		}
+		index.PutNext(key, value)
+		prev = append(prev[:0], key...)
+	}
+
+	switch *in {
+	case "tsv":
+		err = readTSV(input, f, put)
+	case "lp":
+		err = indexer.ReadIndex(input, put)
+	case "dump":
+		err = readDump(dump.Import(input), put)
+	case "jsonl":
+		err = readDump(dump.ImportJSON(input), put)
+	default:
+		err = fmt.Errorf("unknown input format %q", *in)
+	}
+	if err != nil {
+		return err
+	}
+	if putErr != nil {
+		return putErr
+	}
+
+	n, err := indexer.WriteIndexFile(fs.Arg(0), index.Start(nil))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "wrote", n, "keys to", fs.Arg(0))
+	return nil
+}
+
+// Lines of key, tab, value, with both in format f.
+func readTSV(in io.Reader, f format, put func(key, value []byte)) error {
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 64*1024), 1<<30)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.SplitN(s.Text(), "\t", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: expected a key, a tab and a value", line)
+		}
+		key, err := f.parse(fields[0])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		value, err := f.parse(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		put(key, value)
+	}
+	return s.Err()
+}
+
+func readDump(im *dump.Importer, put func(key, value []byte)) error {
+	for {
+		k, v, ok := im.Next()
+		if !ok {
+			return im.Err()
+		}
+		put(k, v)
+	}
+}
+
+func get(args []string) error {
+	fs, formatName := flags("get", true)
+	if err := parse(fs, args, 2, false); err != nil {
+		return err
+	}
+	f, err := getFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	key, err := f.parse(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	index, err := indexer.OpenIndexFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer index.Dispose()
+
+	value, ok := index.Get(key)
+	if !ok {
+		return fmt.Errorf("%s not found", fs.Arg(1))
+	}
+	fmt.Println(f.format(value))
+	return nil
+}
+
+func scan(args []string) error {
+	fs, formatName := flags("scan", true)
+	prefixArg := fs.String("prefix", "", "keys that start with this")
+	startArg := fs.String("start", "", "keys from this one")
+	endArg := fs.String("end", "", "keys before this one, if given")
+	max := fs.Int64("n", -1, "at most this many keys")
+	if err := parse(fs, args, 1, false); err != nil {
+		return err
+	}
+	f, err := getFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	var prefix, start, end []byte
+	for _, a := range []struct {
+		arg *string
+		b   *[]byte
+	}{{prefixArg, &prefix}, {startArg, &start}, {endArg, &end}} {
+		if *a.arg == "" {
+			continue
+		}
+		if *a.b, err = f.parse(*a.arg); err != nil {
+			return err
+		}
+	}
+	if prefix != nil && (start != nil || end != nil) {
+		return fmt.Errorf("give either -prefix or -start and -end")
+	}
+
+	index, err := indexer.OpenIndexFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer index.Dispose()
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	it := scanRange(index, prefix, start, end)
+	for n := int64(0); *max < 0 || n < *max; n++ {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\n", f.format(k), f.format(v))
+	}
+	return nil
+}
+
+type rangeIter struct {
+	it         indexes.Iter
+	start, end []byte
+}
+
+func (r *rangeIter) Next() (key []byte, value []byte, ok bool) {
+	for {
+		key, value, ok = r.it.Next()
+		if !ok {
+			return
+		}
+		if r.end != nil && bytes.Compare(key, r.end) >= 0 {
+			return nil, nil, false
+		}
+		if bytes.Compare(key, r.start) >= 0 {
+			return
+		}
+	}
+}
+
+// Keys that start with prefix, if given, or else keys from start up
+// to end.
+func scanRange(index indexes.ROIndex, prefix, start, end []byte) indexes.Iter {
+	if prefix != nil || (start == nil && end == nil) {
+		return index.Start(prefix)
+	}
+
+	// start where start and end have a prefix in common
+	common := 0
+	if end != nil {
+		for common < len(start) && common < len(end) && start[common] == end[common] {
+			common++
+		}
+	}
+	return &rangeIter{index.Start(start[:common]), start, end}
+}
+
+func stats(args []string) error {
+	fs, _ := flags("stats", false)
+	if err := parse(fs, args, 1, false); err != nil {
+		return err
+	}
+	index, err := indexer.OpenIndexFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer index.Dispose()
+
+	s, err := json.MarshalIndent(struct {
+		Keys   int64
+		Stats  btree.BtreeStats
+		Memory btree.MemoryUsage
+	}{index.Size(), index.Stats(), index.MemoryUsage()}, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(s))
+	return nil
+}
+
+func check(args []string) error {
+	fs, _ := flags("check", false)
+	if err := parse(fs, args, 1, false); err != nil {
+		return err
+	}
+	index, err := indexer.OpenIndexFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer index.Dispose()
+
+	if err := index.CheckConsistency(); err != nil {
+		return err
+	}
+	fmt.Println(index.Size(), "keys, ok")
+	return nil
+}
+
+func dumpTree(args []string) error {
+	fs, _ := flags("dump", false)
+	if err := parse(fs, args, 1, false); err != nil {
+		return err
+	}
+	index, err := indexer.OpenIndexFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer index.Dispose()
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	index.Dump(w)
+	return nil
+}
+
+func export(args []string) error {
+	fs, _ := flags("export", false)
+	asJSON := fs.Bool("json", false, "JSON lines instead of the checksummed binary format")
+	if err := parse(fs, args, 1, true); err != nil {
+		return err
+	}
+	index, err := indexer.OpenIndexFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer index.Dispose()
+
+	out := os.Stdout
+	if fs.NArg() == 2 {
+		if out, err = os.Create(fs.Arg(1)); err != nil {
+			return err
+		}
+	}
+	if *asJSON {
+		_, err = dump.ExportJSON(index.Start(nil), out)
+	} else {
+		_, err = dump.Export(index.Start(nil), out)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,124 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/internal/bounded"
+)
+
+// Index files are the keys and values of a tree in key order, each
+// prefixed with its length as a uvarint.
+
+// Write the keys and values from it to a new index file. Returns the
+// number of keys written.
+func WriteIndexFile(path string, it indexes.Iter) (keys int64, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	if keys, err = WriteIndex(f, it); err != nil {
+		f.Close()
+		return
+	}
+	return keys, f.Close()
+}
+
+// Write the keys and values from it to w in the index file format.
+func WriteIndex(out io.Writer, it indexes.Iter) (keys int64, err error) {
+	w := bufio.NewWriter(out)
+	var hdr [binary.MaxVarintLen64]byte
+	for {
+		k, v, ok := it.Next()
+		if !ok {
+			break
+		}
+		for _, b := range [][]byte{k, v} {
+			n := binary.PutUvarint(hdr[:], uint64(len(b)))
+			if _, err = w.Write(hdr[:n]); err != nil {
+				return
+			}
+			if _, err = w.Write(b); err != nil {
+				return
+			}
+		}
+		keys++
+	}
+	return keys, w.Flush()
+}
+
+// Call f with every key and value in an index file, in order. The
+// slices are only valid during the call.
+func ReadIndexFile(path string, f func(key, value []byte)) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := ReadIndex(in, f); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Longest value ReadIndex accepts. A longer one is taken to mean the
+// file is corrupt.
+const MaxValueSize = 1 << 30
+
+// Call f with every key and value read from in, which is in the index
+// file format. Returns an error if keys or values are empty or too
+// long, or the keys are not in strictly ascending order.
+func ReadIndex(in io.Reader, f func(key, value []byte)) error {
+	r := bufio.NewReader(in)
+	var kv [2][]byte
+	var prev []byte
+	maxLen := [2]uint64{uint64(btree.MaxKeySize), MaxValueSize}
+	for n := 0; ; n++ {
+		for i := range kv {
+			l, err := binary.ReadUvarint(r)
+			if err == io.EOF && i == 0 {
+				return nil
+			}
+			if err != nil {
+				return noEOF(err)
+			}
+			if l > maxLen[i] {
+				return fmt.Errorf("key %d: length %d is more than %d", n, l, maxLen[i])
+			}
+			if kv[i], err = bounded.ReadFull(r, kv[i], int(l)); err != nil {
+				return noEOF(err)
+			}
+		}
+		if len(kv[0]) == 0 || len(kv[1]) == 0 {
+			return fmt.Errorf("key %d: empty key or value", n)
+		}
+		if n > 0 && bytes.Compare(kv[0], prev) <= 0 {
+			return fmt.Errorf("key %d is not after the one before it", n)
+		}
+		f(kv[0], kv[1])
+		prev = append(prev[:0], kv[0]...)
+	}
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Load an index file into an in-memory Btree.
+func OpenIndexFile(path string) (*btree.Btree, error) {
+	bt := btree.NewInMemoryBtree().(*btree.Btree)
+	if err := ReadIndexFile(path, bt.PutNext); err != nil {
+		bt.Dispose()
+		return nil, err
+	}
+	return bt, nil
+}
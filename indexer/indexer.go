@@ -0,0 +1,266 @@
+// Builds indexes on many fields of a stream of events in one pass.
+// Every field gets its own in-memory Btree, from the key the field's
+// extractor makes of an event to the locators of all the events with
+// that key. When the trees together reach the memory budget they are
+// flushed to index files as a segment, and Close records all the
+// segments in a manifest.
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/malloc"
+)
+
+const ManifestFile = "manifest.json"
+
+// Makes the key for a field from an event. ok is false if the event
+// does not have the field.
+//
+// All the locators under a key are one value in the tree, and each
+// Add copies it to append to it, so a key with n events costs O(n²)
+// bytes of copying. Fields with few distinct keys do better with a
+// smaller MemoryBudget, which bounds n.
+type Extractor func(event interface{}) (key []byte, ok bool)
+
+// Makes the value stored for an event under each of its keys,
+// typically where to find the record. Locators of events with the
+// same key are appended to each other, so they should have a fixed
+// size or otherwise be self-delimiting.
+type Locator func(event interface{}) []byte
+
+type Options struct {
+	// Where index files and the manifest go.
+	Dir string
+
+	// Flush when the trees of all the fields together use this
+	// many bytes for what they hold. The fixed overhead of a tree,
+	// its scratch page, empty root and the unused end of its value
+	// store, does not count, so a small budget with many fields
+	// does not flush on every event. Zero means only flush on
+	// Flush and Close.
+	MemoryBudget int64
+
+	// Allocator for the trees. Defaults to malloc.Default.
+	Allocator malloc.Allocator
+}
+
+// Lists what the index files of an Indexer hold.
+type Manifest struct {
+	Fields   []string
+	Segments []Segment
+}
+
+// The index files from one flush.
+type Segment struct {
+	Events int64
+	// by field name
+	Files map[string]File
+}
+
+type File struct {
+	// relative to the directory of the manifest
+	Name string
+	Keys int64
+}
+
+type field struct {
+	name    string
+	extract Extractor
+	tree    *btree.Btree
+	// what the tree uses when it is empty
+	base int64
+}
+
+type Indexer struct {
+	opts     Options
+	locate   Locator
+	fields   []*field
+	events   int64
+	manifest Manifest
+	closed   bool
+}
+
+var fieldName = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+func New(opts Options, locate Locator) *Indexer {
+	return &Indexer{opts: opts, locate: locate}
+}
+
+func (ix *Indexer) resetTree(f *field) {
+	f.tree = btree.NewInMemoryBtreeOptions(btree.Options{Allocator: ix.opts.Allocator}).(*btree.Btree)
+	f.base = f.tree.MemoryUsage().Used()
+}
+
+// Index a field. Names are used in file names, so they may only have
+// letters, digits, '_' and '-'. Fields must all be added before the
+// first event.
+func (ix *Indexer) AddField(name string, extract Extractor) error {
+	if !fieldName.MatchString(name) {
+		return fmt.Errorf("indexer: bad field name %q", name)
+	}
+	for _, f := range ix.fields {
+		if f.name == name {
+			return fmt.Errorf("indexer: field %q added twice", name)
+		}
+	}
+	if ix.events > 0 || len(ix.manifest.Segments) > 0 {
+		return fmt.Errorf("indexer: cannot add field %q after events", name)
+	}
+	f := &field{name: name, extract: extract}
+	ix.resetTree(f)
+	ix.fields = append(ix.fields, f)
+	ix.manifest.Fields = append(ix.manifest.Fields, name)
+	return nil
+}
+
+// Index an event in all the fields it has.
+func (ix *Indexer) Add(event interface{}) error {
+	if ix.closed {
+		return fmt.Errorf("indexer: closed")
+	}
+	locator := ix.locate(event)
+	if len(locator) == 0 {
+		return fmt.Errorf("indexer: empty locator")
+	}
+
+	// Check all the keys first, so that a bad one does not leave
+	// the event in some fields and not others.
+	keys := make([][]byte, len(ix.fields))
+	for i, f := range ix.fields {
+		key, ok := f.extract(event)
+		if !ok {
+			continue
+		}
+		if len(key) == 0 {
+			return fmt.Errorf("indexer: empty key for field %q", f.name)
+		}
+		if err := btree.CheckKey(key); err != nil {
+			return fmt.Errorf("indexer: field %q: %v", f.name, err)
+		}
+		keys[i] = key
+	}
+	for i, f := range ix.fields {
+		if keys[i] != nil {
+			f.tree.Append(keys[i], locator)
+		}
+	}
+	ix.events++
+
+	if ix.opts.MemoryBudget > 0 && ix.usedBytes() >= ix.opts.MemoryBudget {
+		return ix.Flush()
+	}
+	return nil
+}
+
+// Bytes used by the trees of all the fields.
+func (ix *Indexer) MemoryUsage() (total int64) {
+	for _, f := range ix.fields {
+		total += f.tree.MemoryUsage().Total()
+	}
+	return
+}
+
+// What counts against the memory budget.
+func (ix *Indexer) usedBytes() (total int64) {
+	for _, f := range ix.fields {
+		total += f.tree.MemoryUsage().Used() - f.base
+	}
+	return
+}
+
+// Write what has been indexed since the last flush to a new segment
+// of index files, and start afresh.
+func (ix *Indexer) Flush() error {
+	if ix.events == 0 {
+		return nil
+	}
+
+	seg := Segment{ix.events, make(map[string]File)}
+	for _, f := range ix.fields {
+		name := fmt.Sprintf("%s.%06d.idx", f.name, len(ix.manifest.Segments))
+		keys, err := WriteIndexFile(filepath.Join(ix.opts.Dir, name), f.tree.Start([]byte{}))
+		if err != nil {
+			return err
+		}
+		seg.Files[f.name] = File{name, keys}
+	}
+
+	for _, f := range ix.fields {
+		f.tree.Dispose()
+		ix.resetTree(f)
+	}
+	ix.manifest.Segments = append(ix.manifest.Segments, seg)
+	ix.events = 0
+	return nil
+}
+
+// Flush and write the manifest. The Indexer cannot be used after
+// this.
+func (ix *Indexer) Close() (*Manifest, error) {
+	if ix.closed {
+		return nil, fmt.Errorf("indexer: closed twice")
+	}
+	ix.closed = true
+	defer func() {
+		for _, f := range ix.fields {
+			f.tree.Dispose()
+		}
+	}()
+	if err := ix.Flush(); err != nil {
+		return nil, err
+	}
+
+	out, err := json.MarshalIndent(ix.manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(ix.opts.Dir, ManifestFile), out, 0644); err != nil {
+		return nil, err
+	}
+	return &ix.manifest, nil
+}
+
+// Read the manifest in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	in, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(in, m); err != nil {
+		return nil, fmt.Errorf("%s: %v", ManifestFile, err)
+	}
+	return m, nil
+}
+
+// Load all the segments of a field into one in-memory Btree. Keys
+// that appear in several segments get their values appended in
+// segment order.
+func OpenField(dir string, m *Manifest, name string) (*btree.Btree, error) {
+	found := false
+	for _, f := range m.Fields {
+		found = found || f == name
+	}
+	if !found {
+		return nil, fmt.Errorf("indexer: no field %q", name)
+	}
+
+	if len(m.Segments) == 1 {
+		return OpenIndexFile(filepath.Join(dir, m.Segments[0].Files[name].Name))
+	}
+
+	bt := btree.NewInMemoryBtree().(*btree.Btree)
+	for _, seg := range m.Segments {
+		if err := ReadIndexFile(filepath.Join(dir, seg.Files[name].Name), bt.Append); err != nil {
+			bt.Dispose()
+			return nil, err
+		}
+	}
+	return bt, nil
+}
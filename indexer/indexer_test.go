@@ -0,0 +1,262 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/malloc"
+)
+
+type event struct {
+	id     uint64
+	host   string
+	status int
+}
+
+func locate(e interface{}) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, e.(event).id)
+	return b
+}
+
+func host(e interface{}) ([]byte, bool) {
+	return []byte(e.(event).host), true
+}
+
+// Only errors have a status.
+func status(e interface{}) ([]byte, bool) {
+	s := e.(event).status
+	if s < 400 {
+		return nil, false
+	}
+	return []byte(fmt.Sprint(s)), true
+}
+
+func ids(value []byte) []uint64 {
+	ret := make([]uint64, 0)
+	for i := 0; i < len(value); i += 8 {
+		ret = append(ret, binary.BigEndian.Uint64(value[i:]))
+	}
+	return ret
+}
+
+func TestIndexer(t *testing.T) {
+	dir := t.TempDir()
+	ix := New(Options{Dir: dir, MemoryBudget: 4 << 20}, locate)
+	if err := ix.AddField("host", host); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.AddField("status", status); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.AddField("host", host); err == nil {
+		t.Fatal("Expected an error adding a field twice")
+	}
+	if err := ix.AddField("bad/name", host); err == nil {
+		t.Fatal("Expected an error for a bad field name")
+	}
+
+	const n = 100000
+	expectHosts := make(map[string][]uint64)
+	expectStatus := make(map[string][]uint64)
+	for i := uint64(0); i < n; i++ {
+		e := event{i, fmt.Sprintf("host%03d", i%300), 200 + int(i%7)*50}
+		if err := ix.Add(e); err != nil {
+			t.Fatal(err)
+		}
+		expectHosts[e.host] = append(expectHosts[e.host], i)
+		if k, ok := status(e); ok {
+			expectStatus[string(k)] = append(expectStatus[string(k)], i)
+		}
+	}
+
+	if err := ix.AddField("late", host); err == nil {
+		t.Fatal("Expected an error adding a field after events")
+	}
+
+	m, err := ix.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Segments) < 2 {
+		t.Fatal("Expected the budget to cause several segments, got", len(m.Segments))
+	}
+	events := int64(0)
+	for _, seg := range m.Segments {
+		events += seg.Events
+	}
+	if events != n {
+		t.Fatal("Expected", n, "events in the manifest, got", events)
+	}
+
+	read, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Segments) != len(m.Segments) || len(read.Fields) != 2 {
+		t.Fatal("Manifest did not survive the round trip:", read)
+	}
+
+	for name, expect := range map[string]map[string][]uint64{"host": expectHosts, "status": expectStatus} {
+		bt, err := OpenField(dir, read, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bt.Size() != int64(len(expect)) {
+			t.Fatal(name, "expected", len(expect), "keys, got", bt.Size())
+		}
+		for k, want := range expect {
+			v, ok := bt.Get([]byte(k))
+			if !ok {
+				t.Fatal(name, "missing", k)
+			}
+			got := ids(v)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatal(name, k, "expected", want, "got", got)
+			}
+		}
+		bt.Dispose()
+	}
+
+	if _, err := OpenField(dir, read, "nonsense"); err == nil {
+		t.Fatal("Expected an error for an unknown field")
+	}
+	if err := ix.Add(event{}); err == nil {
+		t.Fatal("Expected an error adding to a closed indexer")
+	}
+}
+
+func TestIndexFile(t *testing.T) {
+	path := t.TempDir() + "/test.idx"
+	ix := New(Options{}, locate)
+	ix.AddField("host", host)
+	for i := uint64(0); i < 1000; i++ {
+		ix.Add(event{i, fmt.Sprint(i % 10), 200})
+	}
+	tree := ix.fields[0].tree
+	keys, err := WriteIndexFile(path, tree.Start([]byte{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys != 10 {
+		t.Fatal("Expected 10 keys, got", keys)
+	}
+
+	bt, err := OpenIndexFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bt.Dispose()
+	it1, it2 := tree.Start([]byte{}), bt.Start([]byte{})
+	for {
+		k1, v1, ok1 := it1.Next()
+		k2, v2, ok2 := it2.Next()
+		if ok1 != ok2 || !bytes.Equal(k1, k2) || !bytes.Equal(v1, v2) {
+			t.Fatal("Not the same:", k1, k2)
+		}
+		if !ok1 {
+			break
+		}
+	}
+	tree.Dispose()
+
+	if _, err := OpenIndexFile(path + ".missing"); err == nil {
+		t.Fatal("Expected an error for a missing file")
+	}
+}
+
+func TestAddBadKey(t *testing.T) {
+	ix := New(Options{Dir: t.TempDir()}, locate)
+	ix.AddField("host", host)
+	ix.AddField("long", func(e interface{}) ([]byte, bool) {
+		return make([]byte, btree.MaxKeySize+1), e.(event).status >= 500
+	})
+
+	if err := ix.Add(event{1, "a", 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Add(event{2, "a", 500}); err == nil {
+		t.Fatal("Expected an error for a key that is too long")
+	}
+	v, ok := ix.fields[0].tree.Get([]byte("a"))
+	if !ok || fmt.Sprint(ids(v)) != "[1]" {
+		t.Fatal("Expected the bad event to not be in the first field, got", ids(v))
+	}
+	if ix.events != 1 {
+		t.Fatal("Expected 1 event, got", ix.events)
+	}
+	ix.Close()
+}
+
+func TestSmallBudget(t *testing.T) {
+	ix := New(Options{Dir: t.TempDir(), MemoryBudget: 64 << 10}, locate)
+	const fields = 50
+	for i := 0; i < fields; i++ {
+		ix.AddField(fmt.Sprint("f", i), host)
+	}
+	const n = 10000
+	for i := uint64(0); i < n; i++ {
+		if err := ix.Add(event{i, fmt.Sprint("host", i%100), 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := ix.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Segments) < 2 || len(m.Segments) > n/10 {
+		t.Fatal("Expected the budget to flush now and then, got", len(m.Segments), "segments")
+	}
+}
+
+func TestReadIndexErrors(t *testing.T) {
+	lp := func(lengths ...interface{}) []byte {
+		var b []byte
+		for _, x := range lengths {
+			switch x := x.(type) {
+			case string:
+				b = binary.AppendUvarint(b, uint64(len(x)))
+				b = append(b, x...)
+			case int:
+				// just a length, for what is not there
+				b = binary.AppendUvarint(b, uint64(x))
+			}
+		}
+		return b
+	}
+	for name, in := range map[string][]byte{
+		"out of order":   lp("b", "1", "a", "2"),
+		"duplicate":      lp("a", "1", "a", "2"),
+		"empty key":      lp("", "1"),
+		"empty value":    lp("a", "1", "b", ""),
+		"key too long":   lp(btree.MaxKeySize + 1),
+		"value too long": lp("a", MaxValueSize+1),
+		"truncated":      lp("a", 1<<29),
+		"no value":       lp("a"),
+	} {
+		err := ReadIndex(bytes.NewReader(in), func(key, value []byte) {})
+		if err == nil {
+			t.Error("Expected an error for", name)
+		}
+	}
+	n := 0
+	if err := ReadIndex(bytes.NewReader(lp("a", "1", "b", "2")), func(key, value []byte) { n++ }); err != nil || n != 2 {
+		t.Fatal("Expected two keys, got", n, err)
+	}
+}
+
+func TestCloseFlushError(t *testing.T) {
+	alloc := malloc.NewDebug(malloc.NewArena())
+	ix := New(Options{Dir: t.TempDir() + "/missing", Allocator: alloc}, locate)
+	ix.AddField("host", host)
+	ix.Add(event{1, "a", 200})
+	if _, err := ix.Close(); err == nil {
+		t.Fatal("Expected an error writing to a missing directory")
+	}
+	if count, _ := alloc.Live(); count != 0 {
+		t.Fatal("Expected the trees to be disposed, got", count, "live allocations")
+	}
+}
@@ -0,0 +1,512 @@
+// Correctness checks that any implementation of the interfaces in
+// package indexes can run from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		indexestest.Run(t, func() indexes.Index { return NewThing() }, indexestest.Options{})
+//	}
+//
+// Run checks an indexes.Index against a Go map, RunRO a read-only
+// index built from sorted keys, RunInOrder an index built with
+// PutNext, and Fuzz turns the model-based checks into a fuzz target.
+package indexestest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/avisagie/indexes"
+)
+
+type Options struct {
+	// Checks the index's own invariants, like
+	// Btree.CheckConsistency. Called every now and then.
+	Check func(indexes.ROIndex) error
+
+	// Start does not iterate over keys in order, as with a hash
+	// index. Scans are then not checked.
+	NoScan bool
+
+	// Keys are at most this long. Zero means 64.
+	MaxKeySize int
+
+	// Number of random operations in the model-based test. Zero
+	// means 20000.
+	Ops int
+
+	Seed int64
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxKeySize == 0 {
+		o.MaxKeySize = 64
+	}
+	if o.Ops == 0 {
+		o.Ops = 20000
+	}
+	return o
+}
+
+// An index that can be built with PutNext.
+type InOrderIndex interface {
+	indexes.ROIndex
+	indexes.PutableInOrder
+}
+
+// A key and its value.
+type KV struct {
+	Key, Value []byte
+}
+
+// What an index should contain.
+type model map[string][]byte
+
+func (m model) sorted() []KV {
+	ret := make([]KV, 0, len(m))
+	for k, v := range m {
+		ret = append(ret, KV{[]byte(k), v})
+	}
+	sort.Slice(ret, func(i, j int) bool { return bytes.Compare(ret[i].Key, ret[j].Key) < 0 })
+	return ret
+}
+
+// The keys in index match the model: Size, Get for every key and
+// for keys that are not there, and scans over prefixes.
+func (m model) check(t testing.TB, index indexes.ROIndex, opts Options) {
+	t.Helper()
+	if index.Size() != int64(len(m)) {
+		t.Fatal("expected size", len(m), "got", index.Size())
+	}
+	for k, v := range m {
+		got, ok := index.Get([]byte(k))
+		if !ok || !bytes.Equal(got, v) {
+			t.Fatalf("expected %x => %x, got %x, %v", k, v, got, ok)
+		}
+		if _, ok := index.Get(append([]byte(k), 0)); ok != m.has(k+"\x00") {
+			t.Fatalf("expected %x to be %v", k+"\x00", m.has(k+"\x00"))
+		}
+	}
+	if opts.Check != nil {
+		if err := opts.Check(index); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opts.NoScan {
+		return
+	}
+
+	kvs := m.sorted()
+	m.checkScan(t, index, kvs, nil)
+	m.checkScan(t, index, kvs, []byte{})
+	for _, p := range prefixes(kvs) {
+		m.checkScan(t, index, kvs, p)
+	}
+}
+
+func (m model) has(k string) bool {
+	_, ok := m[k]
+	return ok
+}
+
+// Prefixes worth scanning: of keys, around them and past them.
+func prefixes(kvs []KV) [][]byte {
+	ret := [][]byte{{0}, {0xff}, {0xff, 0xff, 0xff}}
+	for i := 0; i < len(kvs); i += 1 + len(kvs)/20 {
+		k := kvs[i].Key
+		ret = append(ret, k, k[:len(k)/2], k[:1], append(append([]byte{}, k...), 0), incremented(k))
+	}
+	return ret
+}
+
+// The key after k of the same length, or k if there is none.
+func incremented(k []byte) []byte {
+	ret := append([]byte{}, k...)
+	for i := len(ret) - 1; i >= 0; i-- {
+		ret[i]++
+		if ret[i] != 0 {
+			break
+		}
+	}
+	return ret
+}
+
+func (m model) checkScan(t testing.TB, index indexes.ROIndex, kvs []KV, prefix []byte) {
+	t.Helper()
+	it := index.Start(prefix)
+	i := sort.Search(len(kvs), func(i int) bool { return bytes.Compare(kvs[i].Key, prefix) >= 0 })
+	for ; i < len(kvs) && bytes.HasPrefix(kvs[i].Key, prefix); i++ {
+		k, v, ok := it.Next()
+		if !ok || !bytes.Equal(k, kvs[i].Key) || !bytes.Equal(v, kvs[i].Value) {
+			t.Fatalf("scanning %x: expected %x => %x, got %x => %x, %v", prefix, kvs[i].Key, kvs[i].Value, k, v, ok)
+		}
+	}
+	// done, and stays done
+	for j := 0; j < 3; j++ {
+		if k, v, ok := it.Next(); ok || k != nil || v != nil {
+			t.Fatalf("scanning %x: expected the end, got %x => %x, %v", prefix, k, v, ok)
+		}
+	}
+}
+
+// Random keys that share prefixes, so that scans find some.
+func randomKey(r *rand.Rand, maxSize int) []byte {
+	n := 1 + r.Intn(maxSize)
+	if r.Intn(4) > 0 && n > 8 {
+		n = 1 + r.Intn(8)
+	}
+	k := make([]byte, n)
+	for i := range k {
+		// few distinct values early on, anything later
+		if i < 2 {
+			k[i] = byte(r.Intn(4)) * 0x55
+		} else {
+			k[i] = byte(r.Intn(256))
+		}
+	}
+	return k
+}
+
+func randomValue(r *rand.Rand) []byte {
+	n := 1 + r.Intn(16)
+	if r.Intn(100) == 0 {
+		n = 1 + r.Intn(5000)
+	}
+	v := make([]byte, n)
+	r.Read(v)
+	return v
+}
+
+// Run the checks for an indexes.Index.
+func Run(t *testing.T, newIndex func() indexes.Index, opts Options) {
+	opts = opts.withDefaults()
+	t.Run("Empty", func(t *testing.T) { testEmpty(t, newIndex, opts) })
+	t.Run("PutGet", func(t *testing.T) { testPutGet(t, newIndex, opts) })
+	t.Run("Append", func(t *testing.T) { testAppend(t, newIndex, opts) })
+	t.Run("Prefixes", func(t *testing.T) { testPrefixes(t, newIndex, opts) })
+	t.Run("Held", func(t *testing.T) { testHeld(t, newIndex, opts) })
+	t.Run("Model", func(t *testing.T) { testModel(t, newIndex, opts) })
+}
+
+func testEmpty(t *testing.T, newIndex func() indexes.Index, opts Options) {
+	index := newIndex()
+	defer index.Dispose()
+	if v, ok := index.Get([]byte{1}); ok || v != nil {
+		t.Fatal("expected nothing, got", v, ok)
+	}
+	model{}.check(t, index, opts)
+}
+
+func testPutGet(t *testing.T, newIndex func() indexes.Index, opts Options) {
+	index := newIndex()
+	defer index.Dispose()
+	m := model{}
+	put := func(k, v string) {
+		replaced := index.Put([]byte(k), []byte(v))
+		if replaced != m.has(k) {
+			t.Fatalf("putting %q: expected replaced to be %v", k, m.has(k))
+		}
+		m[k] = []byte(v)
+	}
+
+	put("b", "1")
+	put("a", "2")
+	put("ab", "3")
+	put("b", "4")
+	put("\x00", "5")
+	put("\xff\xff", "6")
+	put("a", "7")
+	m.check(t, index, opts)
+
+	// the index keeps its own copies
+	k, v := []byte("c"), []byte("8")
+	index.Put(k, v)
+	m["c"] = []byte("8")
+	k[0], v[0] = 'x', 'x'
+	m.check(t, index, opts)
+}
+
+func testAppend(t *testing.T, newIndex func() indexes.Index, opts Options) {
+	index := newIndex()
+	defer index.Dispose()
+	m := model{}
+	appendTo := func(k, v string) {
+		index.Append([]byte(k), []byte(v))
+		m[k] = append(append([]byte{}, m[k]...), v...)
+	}
+
+	// a new key is a put
+	appendTo("a", "1")
+	appendTo("a", "23")
+	appendTo("b", "x")
+	appendTo("a", "456")
+	index.Put([]byte("b"), []byte("y"))
+	m["b"] = []byte("y")
+	appendTo("b", "z")
+	m.check(t, index, opts)
+
+	// a value that grows well past a page
+	r := rand.New(rand.NewSource(opts.Seed))
+	for i := 0; i < 1000; i++ {
+		appendTo("big", string(randomValue(r)))
+	}
+	m.check(t, index, opts)
+}
+
+// A value from Get stays as it was while the index changes, even when
+// its key gets a new value.
+func testHeld(t *testing.T, newIndex func() indexes.Index, opts Options) {
+	index := newIndex()
+	defer index.Dispose()
+	r := rand.New(rand.NewSource(opts.Seed))
+	var held []struct{ got, want []byte }
+	for i := 0; i < 2000; i++ {
+		k := randomKey(r, opts.MaxKeySize)
+		index.Put(k, randomValue(r))
+		v, ok := index.Get(k)
+		if !ok {
+			t.Fatalf("%q missing", k)
+		}
+		held = append(held, struct{ got, want []byte }{v, append([]byte{}, v...)})
+		index.Put(k, randomValue(r))
+		index.Append(k, randomValue(r))
+	}
+	for _, h := range held {
+		if !bytes.Equal(h.got, h.want) {
+			t.Fatalf("a value held from Get changed from %x to %x", h.want, h.got)
+		}
+	}
+}
+
+func testPrefixes(t *testing.T, newIndex func() indexes.Index, opts Options) {
+	index := newIndex()
+	defer index.Dispose()
+	m := model{}
+
+	// enough keys with the same prefix to fill many pages, between
+	// keys with other prefixes
+	for i := 0; i < 20000; i++ {
+		for _, p := range []string{"a", "ab", "abc", "b\xff", "b\xff\xff"} {
+			if i > 10 && p != "abc" {
+				continue
+			}
+			k := []byte(fmt.Sprintf("%s%05d", p, i))
+			if len(k) > opts.MaxKeySize {
+				continue
+			}
+			index.Put(k, k)
+			m[string(k)] = k
+		}
+	}
+	for _, k := range []string{"", "\x00", "ab", "abc", "abd", "abc1", "abc19999", "b\xff\xff\xff", "c"} {
+		if k != "" && len(k) <= opts.MaxKeySize {
+			index.Put([]byte(k+"!"), []byte(k))
+			m[k+"!"] = []byte(k)
+		}
+	}
+	m.check(t, index, opts)
+}
+
+func testModel(t *testing.T, newIndex func() indexes.Index, opts Options) {
+	index := newIndex()
+	defer index.Dispose()
+	r := rand.New(rand.NewSource(opts.Seed))
+	m := model{}
+	ops := newOps(index, m, opts)
+	for i := 0; i < opts.Ops; i++ {
+		ops.random(t, r)
+		if i%(opts.Ops/5+1) == 0 {
+			m.check(t, index, opts)
+		}
+	}
+	m.check(t, index, opts)
+}
+
+const (
+	opPut = iota
+	opAppend
+	opGet
+	opScan
+	numOps
+)
+
+// Random operations on an index and the model, checked as they go.
+type ops struct {
+	index indexes.Index
+	m     model
+	opts  Options
+	// every key put, to pick existing keys from
+	keys [][]byte
+}
+
+func newOps(index indexes.Index, m model, opts Options) *ops {
+	return &ops{index, m, opts, make([][]byte, 0)}
+}
+
+// An existing key some of the time, otherwise a random one.
+func (o *ops) key(r *rand.Rand) []byte {
+	if len(o.keys) > 0 && r.Intn(3) == 0 {
+		return o.keys[r.Intn(len(o.keys))]
+	}
+	return randomKey(r, o.opts.MaxKeySize)
+}
+
+// Mostly puts and gets. Scans are expensive to check.
+func (o *ops) random(t testing.TB, r *rand.Rand) {
+	op := opScan
+	switch x := r.Intn(100); {
+	case x < 50:
+		op = opPut
+	case x < 70:
+		op = opAppend
+	case x < 99:
+		op = opGet
+	}
+	o.do(t, op, o.key(r), randomValue(r))
+}
+
+func (o *ops) do(t testing.TB, op int, key, value []byte) {
+	t.Helper()
+	if !o.m.has(string(key)) && op != opGet && op != opScan {
+		o.keys = append(o.keys, key)
+	}
+
+	switch op {
+	case opPut:
+		replaced := o.index.Put(key, value)
+		if replaced != o.m.has(string(key)) {
+			t.Fatalf("putting %x: expected replaced to be %v", key, !replaced)
+		}
+		o.m[string(key)] = value
+	case opAppend:
+		o.index.Append(key, value)
+		o.m[string(key)] = append(append([]byte{}, o.m[string(key)]...), value...)
+	case opGet:
+		v, ok := o.index.Get(key)
+		want, wantOK := o.m[string(key)]
+		if ok != wantOK || !bytes.Equal(v, want) {
+			t.Fatalf("getting %x: expected %x, %v, got %x, %v", key, want, wantOK, v, ok)
+		}
+	case opScan:
+		if !o.opts.NoScan {
+			o.m.checkScan(t, o.index, o.m.sorted(), key[:len(key)/2])
+		}
+	}
+	if o.index.Size() != int64(len(o.m)) {
+		t.Fatal("expected size", len(o.m), "got", o.index.Size())
+	}
+}
+
+// Run the checks for a read-only index that build makes from kvs,
+// which are sorted by key.
+func RunRO(t *testing.T, build func(kvs []KV) indexes.ROIndex, opts Options) {
+	opts = opts.withDefaults()
+	r := rand.New(rand.NewSource(opts.Seed))
+	for _, n := range []int{0, 1, 2, 100, 20000} {
+		m := model{}
+		for len(m) < n {
+			m[string(randomKey(r, opts.MaxKeySize))] = randomValue(r)
+		}
+		index := build(m.sorted())
+		m.check(t, index, opts)
+		index.Dispose()
+	}
+}
+
+// Run the checks for an index built with PutNext, including that it
+// panics on keys out of order and is left as it was.
+func RunInOrder(t *testing.T, newIndex func() InOrderIndex, opts Options) {
+	opts = opts.withDefaults()
+	r := rand.New(rand.NewSource(opts.Seed))
+	m := model{}
+	for len(m) < 20000 {
+		m[string(randomKey(r, opts.MaxKeySize))] = randomValue(r)
+	}
+	kvs := m.sorted()
+
+	index := newIndex()
+	defer index.Dispose()
+	for i, kv := range kvs {
+		index.PutNext(kv.Key, kv.Value)
+		if i%1000 != 0 || i == 0 {
+			continue
+		}
+
+		// every key so far is now out of order
+		built := model{}
+		for _, kv := range kvs[:i+1] {
+			built[string(kv.Key)] = kv.Value
+		}
+		for _, k := range [][]byte{kv.Key, kvs[i-1].Key, kvs[i/2].Key, kvs[0].Key, {}} {
+			if len(k) == 0 {
+				continue
+			}
+			if !panics(func() { index.PutNext(k, []byte{1}) }) {
+				t.Fatalf("expected PutNext of %x after %x to panic", k, kv.Key)
+			}
+		}
+		if between := incremented(kvs[i-1].Key); bytes.Compare(between, kv.Key) < 0 && bytes.Compare(between, kvs[i-1].Key) > 0 {
+			if !panics(func() { index.PutNext(between, []byte{1}) }) {
+				t.Fatalf("expected PutNext of %x after %x to panic", between, kv.Key)
+			}
+		}
+		if i%5000 == 0 {
+			built.check(t, index, opts)
+		}
+	}
+	m.check(t, index, opts)
+}
+
+func panics(f func()) (panicked bool) {
+	defer func() {
+		panicked = recover() != nil
+	}()
+	f()
+	return
+}
+
+// A fuzz target over random operations on an index, checked against a
+// map. Call it from a FuzzXxx function:
+//
+//	func FuzzThing(f *testing.F) {
+//		indexestest.Fuzz(f, func() indexes.Index { return NewThing() }, indexestest.Options{})
+//	}
+func Fuzz(f *testing.F, newIndex func() indexes.Index, opts Options) {
+	opts = opts.withDefaults()
+	f.Add([]byte{opPut, 1, 'a', 1, 'x', opPut, 2, 'a', 'b', 1, 'y', opGet, 1, 'a', 1, 0})
+	f.Add([]byte{opPut, 1, 'a', 2, 'x', 'y', opAppend, 1, 'a', 1, 'z', opScan, 1, 'a', 1, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		index := newIndex()
+		defer index.Dispose()
+		o := newOps(index, model{}, opts)
+		for len(data) > 0 {
+			var op int
+			var key, value []byte
+			op, data = int(data[0])%numOps, data[1:]
+			key, data = field(data, opts.MaxKeySize)
+			value, data = field(data, 1<<16)
+			if len(key) == 0 || len(value) == 0 {
+				continue
+			}
+			o.do(t, op, key, value)
+		}
+		o.m.check(t, index, opts)
+	})
+}
+
+// A length byte and that many bytes, up to max.
+func field(data []byte, max int) ([]byte, []byte) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	n := int(data[0])
+	if n > max {
+		n = max
+	}
+	data = data[1:]
+	if n > len(data) {
+		n = len(data)
+	}
+	return data[:n], data[n:]
+}
@@ -0,0 +1,85 @@
+package indexestest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/avisagie/indexes"
+)
+
+// The simplest index there is, to check the checks.
+type sliceIndex struct {
+	kvs []KV
+}
+
+func (s *sliceIndex) find(key []byte) (int, bool) {
+	i := sort.Search(len(s.kvs), func(i int) bool { return bytes.Compare(s.kvs[i].Key, key) >= 0 })
+	return i, i < len(s.kvs) && bytes.Equal(s.kvs[i].Key, key)
+}
+
+func (s *sliceIndex) Get(key []byte) ([]byte, bool) {
+	if i, ok := s.find(key); ok {
+		return s.kvs[i].Value, true
+	}
+	return nil, false
+}
+
+func (s *sliceIndex) Put(key, value []byte) bool {
+	kv := KV{append([]byte{}, key...), append([]byte{}, value...)}
+	i, ok := s.find(key)
+	if ok {
+		s.kvs[i] = kv
+		return true
+	}
+	s.kvs = append(s.kvs, KV{})
+	copy(s.kvs[i+1:], s.kvs[i:])
+	s.kvs[i] = kv
+	return false
+}
+
+func (s *sliceIndex) Append(key, value []byte) {
+	old, _ := s.Get(key)
+	s.Put(key, append(append([]byte{}, old...), value...))
+}
+
+func (s *sliceIndex) PutNext(key, value []byte) {
+	if len(s.kvs) > 0 && bytes.Compare(key, s.kvs[len(s.kvs)-1].Key) <= 0 {
+		panic(fmt.Sprint("out of order put: ", key))
+	}
+	s.Put(key, value)
+}
+
+func (s *sliceIndex) Start(prefix []byte) indexes.Iter {
+	i, _ := s.find(prefix)
+	return &sliceIter{s.kvs[i:], prefix}
+}
+
+func (s *sliceIndex) Size() int64 { return int64(len(s.kvs)) }
+func (s *sliceIndex) Dispose()    {}
+
+type sliceIter struct {
+	kvs    []KV
+	prefix []byte
+}
+
+func (it *sliceIter) Next() (key []byte, value []byte, ok bool) {
+	if len(it.kvs) == 0 || !bytes.HasPrefix(it.kvs[0].Key, it.prefix) {
+		it.kvs = nil
+		return
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv.Key, kv.Value, true
+}
+
+func TestSuite(t *testing.T) {
+	Run(t, func() indexes.Index { return &sliceIndex{} }, Options{Ops: 5000})
+	RunInOrder(t, func() InOrderIndex { return &sliceIndex{} }, Options{})
+	RunRO(t, func(kvs []KV) indexes.ROIndex { return &sliceIndex{kvs} }, Options{})
+}
+
+func FuzzSliceIndex(f *testing.F) {
+	Fuzz(f, func() indexes.Index { return &sliceIndex{} }, Options{})
+}
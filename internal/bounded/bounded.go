@@ -0,0 +1,29 @@
+// Reads of lengths that come from the input itself, and so cannot be
+// trusted.
+package bounded
+
+import "io"
+
+// Read n bytes from r, into buf if it is big enough. Grows the buffer
+// a chunk at a time as the bytes arrive rather than all at once, so
+// that a corrupt length does not allocate much before the input runs
+// out.
+func ReadFull(r io.Reader, buf []byte, n int) ([]byte, error) {
+	const chunk = 1 << 20
+	b := buf[:0]
+	for len(b) < n {
+		l := len(b)
+		grow := n - l
+		if grow > chunk && grow > cap(b)-l {
+			grow = chunk
+		}
+		if cap(b) < l+grow {
+			b = append(b[:cap(b)], make([]byte, l+grow-cap(b))...)
+		}
+		b = b[:l+grow]
+		if _, err := io.ReadFull(r, b[l:]); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,26 @@
+package bounded
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadFull(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300000)
+	buf := make([]byte, 10)
+	got, err := ReadFull(bytes.NewReader(data), buf, len(data))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatal("Expected all", len(data), "bytes back, got", len(got), err)
+	}
+	got, err = ReadFull(bytes.NewReader(data), got, 5)
+	if err != nil || string(got) != "01234" || cap(got) < len(data) {
+		t.Fatal("Expected the buffer to be reused, got", string(got), cap(got), err)
+	}
+
+	// a length far past the end allocates about what is there
+	got, err = ReadFull(bytes.NewReader(data[:100]), nil, 1<<40)
+	if err != io.ErrUnexpectedEOF || cap(got) > 2<<20 {
+		t.Fatal("Expected a short read in a small buffer, got", cap(got), err)
+	}
+}
@@ -0,0 +1,78 @@
+package malloc
+
+import (
+	"sync"
+)
+
+const (
+	// Arenas are carved into blocks of one size class each.
+	arenaSize = 1 << 20
+
+	minClassShift = 6
+	maxClassShift = 18
+)
+
+// Pure Go slab allocator. Sizes are rounded up to a power of two
+// between 64 bytes and 256KB, and blocks of a size class are carved
+// from 1MB arenas and recycled on free lists, so the GC sees a few
+// big objects instead of many small ones. Larger allocations come
+// straight from the Go heap and are left to the GC when freed.
+type arena struct {
+	lock sync.Mutex
+
+	// free blocks and the unused rest of the current arena, per
+	// size class
+	free [maxClassShift + 1][][]byte
+	rest [maxClassShift + 1][]byte
+}
+
+func init() {
+	register("arena", NewArena)
+}
+
+// Allocator that does not need cgo. See arena.
+func NewArena() Allocator {
+	return &arena{}
+}
+
+func sizeClass(size int) (shift uint) {
+	for shift = minClassShift; 1<<shift < size; shift++ {
+	}
+	return
+}
+
+func (a *arena) Malloc(size int) []byte {
+	shift := sizeClass(size)
+	if shift > maxClassShift {
+		return make([]byte, size)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	classSize := 1 << shift
+	if free := a.free[shift]; len(free) > 0 {
+		buf := free[len(free)-1]
+		a.free[shift] = free[:len(free)-1]
+		return buf[:size]
+	}
+
+	if len(a.rest[shift]) < classSize {
+		a.rest[shift] = make([]byte, arenaSize)
+	}
+	buf := a.rest[shift][:classSize:classSize]
+	a.rest[shift] = a.rest[shift][classSize:]
+	return buf[:size]
+}
+
+func (a *arena) Free(buf []byte) {
+	shift := sizeClass(cap(buf))
+	if shift > maxClassShift || 1<<shift != cap(buf) {
+		// not one of ours, let the GC have it
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.free[shift] = append(a.free[shift], buf[:cap(buf)])
+}
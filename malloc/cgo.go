@@ -0,0 +1,35 @@
+//go:build cgo
+// +build cgo
+
+package malloc
+
+// #include <malloc.h>
+import "C"
+import (
+	"reflect"
+	"unsafe"
+)
+
+type cgoAllocator struct{}
+
+func init() {
+	register("cgo", NewCgo)
+}
+
+// Allocator that uses C's malloc and free.
+func NewCgo() Allocator {
+	return cgoAllocator{}
+}
+
+func (cgoAllocator) Malloc(size int) (ret []byte) {
+	s := (*reflect.SliceHeader)(unsafe.Pointer(&ret))
+	s.Data = uintptr(unsafe.Pointer(C.malloc(C.size_t(size))))
+	s.Len = size
+	s.Cap = size
+	return
+}
+
+func (cgoAllocator) Free(buf []byte) {
+	s := (*reflect.SliceHeader)(unsafe.Pointer(&buf))
+	C.free(unsafe.Pointer(s.Data))
+}
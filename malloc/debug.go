@@ -0,0 +1,172 @@
+package malloc
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+const debugStackDepth = 16
+
+type allocation struct {
+	size  int
+	stack []uintptr
+}
+
+// Allocator that keeps track of every live allocation of the one it
+// wraps, along with its size and the stack that allocated it. Panics
+// on a double free or a free of something it did not hand out, and
+// reports what was never freed. Slow, meant for tests. Build with the
+// mallocdebug tag to make it wrap Default.
+type Debug struct {
+	inner Allocator
+
+	lock sync.Mutex
+	live map[uintptr]allocation
+	// stacks that freed what is no longer live, to explain double
+	// frees
+	freed map[uintptr][]uintptr
+}
+
+// A live allocation, as reported by Leaks.
+type Leak struct {
+	Size  int
+	Stack string
+}
+
+func NewDebug(inner Allocator) *Debug {
+	return &Debug{
+		inner: inner,
+		live:  make(map[uintptr]allocation),
+		freed: make(map[uintptr][]uintptr),
+	}
+}
+
+func callers() []uintptr {
+	pcs := make([]uintptr, debugStackDepth)
+	return pcs[:runtime.Callers(3, pcs)]
+}
+
+func formatStack(pcs []uintptr) string {
+	buf := &strings.Builder{}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(buf, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return buf.String()
+}
+
+func address(buf []byte) uintptr {
+	return (*reflect.SliceHeader)(unsafe.Pointer(&buf)).Data
+}
+
+func (d *Debug) Malloc(size int) []byte {
+	buf := d.inner.Malloc(size)
+	if cap(buf) == 0 {
+		// nothing to track, and empty slices may share an
+		// address
+		return buf
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	p := address(buf)
+	if _, ok := d.live[p]; ok {
+		panic(fmt.Sprintf("malloc: %#x handed out twice", p))
+	}
+	d.live[p] = allocation{size, callers()}
+	delete(d.freed, p)
+	return buf
+}
+
+func (d *Debug) Free(buf []byte) {
+	if cap(buf) == 0 {
+		d.inner.Free(buf)
+		return
+	}
+
+	d.lock.Lock()
+	p := address(buf)
+	if _, ok := d.live[p]; !ok {
+		stack, wasFreed := d.freed[p]
+		d.lock.Unlock()
+		if wasFreed {
+			panic(fmt.Sprintf("malloc: double free of %#x, first freed by:\n%s", p, formatStack(stack)))
+		}
+		panic(fmt.Sprintf("malloc: free of unknown pointer %#x", p))
+	}
+	delete(d.live, p)
+	d.freed[p] = callers()
+	d.lock.Unlock()
+
+	d.inner.Free(buf)
+}
+
+// Number and total size of live allocations.
+func (d *Debug) Live() (count int, bytes int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, a := range d.live {
+		bytes += a.size
+	}
+	return len(d.live), bytes
+}
+
+// Live allocations, biggest first.
+func (d *Debug) Leaks() []Leak {
+	d.lock.Lock()
+	allocations := make([]allocation, 0, len(d.live))
+	for _, a := range d.live {
+		allocations = append(allocations, a)
+	}
+	d.lock.Unlock()
+
+	ret := make([]Leak, 0, len(allocations))
+	for _, a := range allocations {
+		ret = append(ret, Leak{a.size, formatStack(a.stack)})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Size > ret[j].Size
+	})
+	return ret
+}
+
+// Write the live allocations with their stacks to out, and return
+// how many there are. Live allocations that share a stack are
+// reported once.
+func (d *Debug) Report(out io.Writer) (leaks int) {
+	type group struct {
+		count, bytes int
+	}
+	groups := make(map[string]*group)
+	stacks := make([]string, 0)
+	total := 0
+	for _, l := range d.Leaks() {
+		g, ok := groups[l.Stack]
+		if !ok {
+			g = &group{}
+			groups[l.Stack] = g
+			stacks = append(stacks, l.Stack)
+		}
+		g.count++
+		g.bytes += l.Size
+		total += l.Size
+		leaks++
+	}
+
+	fmt.Fprintf(out, "malloc: %d live allocations, %d bytes\n", leaks, total)
+	for _, s := range stacks {
+		g := groups[s]
+		fmt.Fprintf(out, "%d allocations, %d bytes, allocated at:\n%s", g.count, g.bytes, s)
+	}
+	return
+}
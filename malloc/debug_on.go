@@ -0,0 +1,6 @@
+//go:build mallocdebug
+// +build mallocdebug
+
+package malloc
+
+const debugDefault = true
@@ -0,0 +1,9 @@
+//go:build (!cgo || purego) && !mmapalloc
+// +build !cgo purego
+// +build !mmapalloc
+
+package malloc
+
+func defaultAllocator() Allocator {
+	return NewArena()
+}
@@ -0,0 +1,8 @@
+//go:build cgo && !purego && !mmapalloc
+// +build cgo,!purego,!mmapalloc
+
+package malloc
+
+func defaultAllocator() Allocator {
+	return NewCgo()
+}
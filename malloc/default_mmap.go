@@ -0,0 +1,9 @@
+//go:build mmapalloc && (linux || darwin || freebsd || netbsd || openbsd)
+// +build mmapalloc
+// +build linux darwin freebsd netbsd openbsd
+
+package malloc
+
+func defaultAllocator() Allocator {
+	return NewMmap()
+}
@@ -0,0 +1,9 @@
+//go:build mmapalloc && !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build mmapalloc,!linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package malloc
+
+// There is no mmap here, so mmapalloc falls back to the arena.
+func defaultAllocator() Allocator {
+	return NewArena()
+}
@@ -1,27 +1,64 @@
+// Allocation of byte slices that the garbage collector does not have
+// to worry about, either because they live outside the Go heap or
+// because they are carved out of a few big objects.
 package malloc
 
-// #include <malloc.h>
-import "C"
 import (
-	"reflect"
-	"unsafe"
+	"fmt"
+	"sort"
 )
 
-var pointers map[unsafe.Pointer]struct{}
+// Hands out byte slices of the requested size. Give them back with
+// Free, and do not touch them afterwards. Implementations are safe
+// for concurrent use.
+type Allocator interface {
+	Malloc(size int) []byte
+	Free(buf []byte)
+}
+
+// The allocator behind Malloc and Free. Chosen at build time: cgo
+// malloc when cgo is enabled, the arena with the purego build tag or
+// without cgo, and anonymous mmap with the mmapalloc build tag, or
+// the arena where there is no mmap. The mallocdebug build tag wraps it
+// in a Debug.
+var Default Allocator
+
+var backends = make(map[string]func() Allocator)
 
 func init() {
-	pointers = make(map[unsafe.Pointer]struct{})
+	Default = defaultAllocator()
+	if debugDefault {
+		Default = NewDebug(Default)
+	}
+}
+
+func register(name string, newAllocator func() Allocator) {
+	backends[name] = newAllocator
+}
+
+// Create a new allocator by backend name. See Names.
+func New(name string) (Allocator, error) {
+	newAllocator, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("malloc: unknown allocator %q", name)
+	}
+	return newAllocator(), nil
+}
+
+// Names of the allocator backends available in this build.
+func Names() []string {
+	ret := make([]string, 0, len(backends))
+	for name := range backends {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
 }
 
-func Malloc(size int) (ret []byte) {
-	s := (*reflect.SliceHeader)(unsafe.Pointer(&ret))
-	s.Data = uintptr(unsafe.Pointer(C.malloc(C.size_t(size))))
-	s.Len = size
-	s.Cap = size
-	return
+func Malloc(size int) []byte {
+	return Default.Malloc(size)
 }
 
 func Free(buf []byte) {
-	s := (*reflect.SliceHeader)(unsafe.Pointer(&buf))
-	C.free(unsafe.Pointer(s.Data))
+	Default.Free(buf)
 }
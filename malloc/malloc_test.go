@@ -0,0 +1,138 @@
+package malloc
+
+import (
+	"io"
+	"testing"
+)
+
+func TestAllocators(t *testing.T) {
+	if len(Names()) == 0 {
+		t.Fatal("Expected some allocators")
+	}
+	if _, err := New("nonsense"); err == nil {
+		t.Fatal("Expected an error for an unknown allocator")
+	}
+
+	for _, name := range Names() {
+		a, err := New(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bufs := make([][]byte, 0)
+		for _, size := range []int{0, 1, 63, 64, 65, 4096, 16 << 10, 1 << 20, 3<<20 + 1} {
+			buf := a.Malloc(size)
+			if len(buf) != size {
+				t.Fatal(name, "expected", size, "bytes, got", len(buf))
+			}
+			for i := range buf {
+				buf[i] = byte(size + i)
+			}
+			bufs = append(bufs, buf)
+		}
+
+		for _, buf := range bufs {
+			size := len(buf)
+			for i := range buf {
+				if buf[i] != byte(size+i) {
+					t.Fatal(name, "allocations overlap")
+				}
+			}
+			a.Free(buf)
+		}
+	}
+}
+
+func TestArenaReuse(t *testing.T) {
+	a := NewArena()
+	buf := a.Malloc(100)
+	a.Free(buf)
+	again := a.Malloc(120)
+	if &buf[0] != &again[0] {
+		t.Fatal("Expected the freed block to be reused")
+	}
+	if cap(again) != 128 {
+		t.Fatal("Expected size class 128, got", cap(again))
+	}
+}
+
+func expectPanic(t *testing.T, f func()) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("Expected a panic")
+		} else {
+			t.Log(r)
+		}
+	}()
+	f()
+}
+
+func TestDebug(t *testing.T) {
+	d := NewDebug(NewArena())
+	a := d.Malloc(100)
+	b := d.Malloc(1000)
+	if count, bytes := d.Live(); count != 2 || bytes != 1100 {
+		t.Fatal("Expected 2 allocations of 1100 bytes, got", count, bytes)
+	}
+
+	d.Free(a)
+	leaks := d.Leaks()
+	if len(leaks) != 1 || leaks[0].Size != 1000 || leaks[0].Stack == "" {
+		t.Fatal("Expected one leak of 1000 bytes with a stack, got", leaks)
+	}
+	if n := d.Report(io.Discard); n != 1 {
+		t.Fatal("Expected 1 leak reported, got", n)
+	}
+
+	expectPanic(t, func() { d.Free(a) })
+	expectPanic(t, func() { d.Free(make([]byte, 10)) })
+
+	d.Free(b)
+	if count, _ := d.Live(); count != 0 {
+		t.Fatal("Expected no live allocations, got", count)
+	}
+}
+
+func TestRegion(t *testing.T) {
+	d := NewDebug(Default)
+	r := NewRegion(d)
+
+	refs := make(map[int64]int)
+	for _, size := range []int{1, 8, 9, 100, RegionChunkSize - 8, RegionChunkSize + 1, 3, 16} {
+		ref := r.Alloc(size)
+		if ref%8 != 0 {
+			t.Fatal("Expected aligned references, got", ref)
+		}
+		b := r.Bytes(ref, size)
+		for i := range b {
+			b[i] = byte(size)
+		}
+		refs[ref] = size
+	}
+	for ref, size := range refs {
+		for _, c := range r.Bytes(ref, size) {
+			if c != byte(size) {
+				t.Fatal("Allocations overlap at", ref)
+			}
+		}
+	}
+
+	// freed space is reused for the same size
+	ref := r.Alloc(24)
+	r.Free(ref, 20)
+	if again := r.Alloc(17); again != ref {
+		t.Fatal("Expected", ref, "again, got", again)
+	}
+
+	big := r.Alloc(2 * RegionChunkSize)
+	before := r.Size()
+	r.Free(big, 2*RegionChunkSize)
+	if r.Size() != before-2*RegionChunkSize {
+		t.Fatal("Expected a big allocation to go back to the allocator")
+	}
+
+	r.Dispose()
+	if count, _ := d.Live(); count != 0 {
+		t.Fatal("Leaked", count, "allocations")
+	}
+}
@@ -0,0 +1,41 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package malloc
+
+import (
+	"fmt"
+	"syscall"
+)
+
+type mmapAllocator struct{}
+
+func init() {
+	register("mmap", NewMmap)
+}
+
+// Allocator that maps anonymous memory for every allocation. Only
+// sensible for big allocations, since each one takes whole pages.
+func NewMmap() Allocator {
+	return mmapAllocator{}
+}
+
+func (mmapAllocator) Malloc(size int) []byte {
+	if size == 0 {
+		return []byte{}
+	}
+	buf, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		panic(fmt.Sprint("mmap of ", size, " bytes failed: ", err))
+	}
+	return buf
+}
+
+func (mmapAllocator) Free(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+	if err := syscall.Munmap(buf[:cap(buf)]); err != nil {
+		panic(fmt.Sprint("munmap failed: ", err))
+	}
+}
@@ -0,0 +1,94 @@
+package malloc
+
+const RegionChunkSize = 1 << 20
+
+// Hands out space in chunks from an Allocator, by reference rather
+// than by slice: references are chunk*RegionChunkSize+offset, so
+// structures in a region can point at each other with plain int64s
+// that the garbage collector does not follow. Allocations are 8 byte
+// aligned. Freed space goes on a free list for its size. Allocations
+// bigger than a chunk get a chunk of their own, which Free gives back
+// to the Allocator. Not safe for concurrent use.
+type Region struct {
+	alloc  Allocator
+	chunks [][]byte
+	cur    []byte
+	curi   int
+	used   int
+	free   map[int][]int64
+	size   int64
+}
+
+func NewRegion(alloc Allocator) *Region {
+	return &Region{alloc: alloc, chunks: make([][]byte, 0), free: make(map[int][]int64)}
+}
+
+func roundUp(n int) int {
+	return (n + 7) &^ 7
+}
+
+// Allocate n bytes and return a reference to them. The bytes are not
+// zeroed.
+func (r *Region) Alloc(n int) int64 {
+	if n > RegionChunkSize {
+		buf := r.alloc.Malloc(n)
+		r.size += int64(n)
+		r.chunks = append(r.chunks, buf)
+		return RegionChunkSize * int64(len(r.chunks)-1)
+	}
+
+	n = roundUp(n)
+	if free := r.free[n]; len(free) > 0 {
+		ref := free[len(free)-1]
+		r.free[n] = free[:len(free)-1]
+		return ref
+	}
+
+	if r.used+n > len(r.cur) {
+		r.cur = r.alloc.Malloc(RegionChunkSize)
+		r.size += RegionChunkSize
+		r.chunks = append(r.chunks, r.cur)
+		r.curi = len(r.chunks) - 1
+		r.used = 0
+	}
+	ref := RegionChunkSize*int64(r.curi) + int64(r.used)
+	r.used += n
+	return ref
+}
+
+// Give back the n bytes at ref, as allocated by Alloc(n).
+func (r *Region) Free(ref int64, n int) {
+	if n > RegionChunkSize {
+		i := ref / RegionChunkSize
+		r.alloc.Free(r.chunks[i])
+		r.size -= int64(n)
+		r.chunks[i] = nil
+		return
+	}
+	n = roundUp(n)
+	r.free[n] = append(r.free[n], ref)
+}
+
+// The n bytes at ref. Its capacity is its length, so appending to it
+// copies.
+func (r *Region) Bytes(ref int64, n int) []byte {
+	o := int(ref % RegionChunkSize)
+	return r.chunks[ref/RegionChunkSize][o : o+n : o+n]
+}
+
+// Bytes taken from the Allocator.
+func (r *Region) Size() int64 {
+	return r.size
+}
+
+// Free all the chunks.
+func (r *Region) Dispose() {
+	for _, c := range r.chunks {
+		if c != nil {
+			r.alloc.Free(c)
+		}
+	}
+	r.chunks = nil
+	r.cur = nil
+	r.free = nil
+}
@@ -0,0 +1,92 @@
+package postings
+
+// Delta encoding: after the header, the first ID and then the gaps
+// between consecutive IDs, all as uvarints.
+type deltaCodec struct{}
+
+var Delta Codec = deltaCodec{}
+
+func (deltaCodec) Encode(ids []uint64) []byte {
+	checkSorted(ids)
+	if len(ids) == 0 {
+		return appendHeader(nil, 0, 0)
+	}
+	ret := appendHeader(make([]byte, 0, 4+len(ids)*2), len(ids), ids[len(ids)-1])
+	prev := uint64(0)
+	for _, id := range ids {
+		ret = appendUvarint(ret, id-prev)
+		prev = id
+	}
+	return ret
+}
+
+func (c deltaCodec) Decode(list []byte) []uint64 {
+	ret := make([]uint64, 0, Len(list))
+	it := c.Iter(list)
+	for {
+		id, ok := it.Next()
+		if !ok {
+			return ret
+		}
+		ret = append(ret, id)
+	}
+}
+
+type deltaIter struct {
+	list      []byte
+	pos       int
+	remaining uint64
+	cur       uint64
+	started   bool
+}
+
+func (deltaCodec) Iter(list []byte) Iterator {
+	count, _, pos := readHeader(list)
+	return &deltaIter{list, pos, count, 0, false}
+}
+
+func (d *deltaIter) Next() (uint64, bool) {
+	if d.remaining == 0 {
+		return 0, false
+	}
+	var gap uint64
+	gap, d.pos = uvarint(d.list, d.pos)
+	d.cur += gap
+	d.remaining--
+	d.started = true
+	return d.cur, true
+}
+
+func (d *deltaIter) SkipTo(target uint64) (uint64, bool) {
+	if d.started && d.cur >= target {
+		return d.cur, true
+	}
+	for {
+		id, ok := d.Next()
+		if !ok || id >= target {
+			return id, ok
+		}
+	}
+}
+
+func (c deltaCodec) Merge(a, b []byte) []byte {
+	countA, lastA, bodyA := readHeader(a)
+	countB, lastB, bodyB := readHeader(b)
+	if countA == 0 {
+		return append([]byte{}, b...)
+	}
+	if countB == 0 {
+		return append([]byte{}, a...)
+	}
+
+	firstB, restB := uvarint(b, bodyB)
+	if firstB <= lastA {
+		return c.Encode(union(c.Decode(a), c.Decode(b)))
+	}
+
+	// b goes after a: its first ID becomes a gap from a's last
+	ret := appendHeader(make([]byte, 0, len(a)+len(b)), int(countA+countB), lastB)
+	ret = append(ret, a[bodyA:]...)
+	ret = appendUvarint(ret, firstB-lastA)
+	return append(ret, b[restB:]...)
+}
@@ -0,0 +1,229 @@
+package postings
+
+import (
+	"math/bits"
+)
+
+// Bit packed blocks of up to packedBlockSize IDs. After the header
+// come the blocks, each with:
+//
+//	uvarint  gap from the last ID of the previous block to its first
+//	uvarint  gap from its first ID to its last
+//	byte     bits per gap
+//	byte     number of IDs - 1
+//	         the gaps between its IDs, packed
+//
+// Knowing the last ID of a block lets SkipTo pass over it without
+// unpacking it.
+type packedCodec struct{}
+
+var Packed Codec = packedCodec{}
+
+const packedBlockSize = 128
+
+type blockHeader struct {
+	first, last uint64
+	width       uint
+	n           int
+	// where the packed gaps start and end
+	start, end int
+}
+
+func readBlockHeader(list []byte, pos int, prevLast uint64) (h blockHeader) {
+	var gap, span uint64
+	gap, pos = uvarint(list, pos)
+	span, pos = uvarint(list, pos)
+	h.first = prevLast + gap
+	h.last = h.first + span
+	h.width = uint(list[pos])
+	h.n = int(list[pos+1]) + 1
+	h.start = pos + 2
+	h.end = h.start + ((h.n-1)*int(h.width)+7)/8
+	return
+}
+
+func (h blockHeader) decode(list []byte, dst []uint64) []uint64 {
+	dst = append(dst, h.first)
+	id := h.first
+	bit := h.start * 8
+	for i := 1; i < h.n; i++ {
+		gap := uint64(0)
+		for b := uint(0); b < h.width; {
+			off := uint(bit % 8)
+			n := 8 - off
+			if n > h.width-b {
+				n = h.width - b
+			}
+			gap |= uint64(list[bit/8]>>off&(1<<n-1)) << b
+			bit += int(n)
+			b += n
+		}
+		id += gap
+		dst = append(dst, id)
+	}
+	return dst
+}
+
+func encodeBlocks(dst []byte, ids []uint64, prevLast uint64) []byte {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > packedBlockSize {
+			n = packedBlockSize
+		}
+		block := ids[:n]
+		ids = ids[n:]
+
+		width := uint(0)
+		for i := 1; i < n; i++ {
+			if w := uint(bits.Len64(block[i] - block[i-1])); w > width {
+				width = w
+			}
+		}
+
+		dst = appendUvarint(dst, block[0]-prevLast)
+		dst = appendUvarint(dst, block[n-1]-block[0])
+		dst = append(dst, byte(width), byte(n-1))
+
+		start := len(dst)
+		dst = append(dst, make([]byte, ((n-1)*int(width)+7)/8)...)
+		bit := start * 8
+		for i := 1; i < n; i++ {
+			gap := block[i] - block[i-1]
+			for b := uint(0); b < width; {
+				off := uint(bit % 8)
+				c := 8 - off
+				if c > width-b {
+					c = width - b
+				}
+				dst[bit/8] |= byte(gap>>b&(1<<c-1)) << off
+				bit += int(c)
+				b += c
+			}
+		}
+		prevLast = block[n-1]
+	}
+	return dst
+}
+
+func (packedCodec) Encode(ids []uint64) []byte {
+	checkSorted(ids)
+	if len(ids) == 0 {
+		return appendHeader(nil, 0, 0)
+	}
+	ret := appendHeader(make([]byte, 0, 4+len(ids)), len(ids), ids[len(ids)-1])
+	return encodeBlocks(ret, ids, 0)
+}
+
+func (packedCodec) Decode(list []byte) []uint64 {
+	count, _, pos := readHeader(list)
+	ret := make([]uint64, 0, count)
+	prevLast := uint64(0)
+	for pos < len(list) {
+		h := readBlockHeader(list, pos, prevLast)
+		ret = h.decode(list, ret)
+		pos, prevLast = h.end, h.last
+	}
+	return ret
+}
+
+type packedIter struct {
+	list []byte
+	// start of the next block
+	pos      int
+	prevLast uint64
+	block    []uint64
+	i        int
+	done     bool
+}
+
+func (packedCodec) Iter(list []byte) Iterator {
+	_, _, pos := readHeader(list)
+	return &packedIter{list, pos, 0, make([]uint64, 0, packedBlockSize), -1, false}
+}
+
+// unpack the next block
+func (p *packedIter) nextBlock() bool {
+	if p.pos >= len(p.list) {
+		return false
+	}
+	h := readBlockHeader(p.list, p.pos, p.prevLast)
+	p.block = h.decode(p.list, p.block[:0])
+	p.i = -1
+	p.pos, p.prevLast = h.end, h.last
+	return true
+}
+
+func (p *packedIter) Next() (uint64, bool) {
+	if p.done {
+		return 0, false
+	}
+	if p.i+1 >= len(p.block) {
+		if !p.nextBlock() {
+			p.done = true
+			return 0, false
+		}
+	}
+	p.i++
+	return p.block[p.i], true
+}
+
+func (p *packedIter) SkipTo(target uint64) (uint64, bool) {
+	if p.done {
+		return 0, false
+	}
+	if p.i >= 0 && p.block[p.i] >= target {
+		return p.block[p.i], true
+	}
+
+	if len(p.block) == 0 || p.block[len(p.block)-1] < target {
+		// pass over the blocks that end before target
+		for p.pos < len(p.list) {
+			h := readBlockHeader(p.list, p.pos, p.prevLast)
+			if h.last >= target {
+				break
+			}
+			p.pos, p.prevLast = h.end, h.last
+		}
+		if !p.nextBlock() {
+			p.done = true
+			return 0, false
+		}
+	}
+
+	if p.i < 0 {
+		p.i = 0
+	}
+	for p.block[p.i] < target {
+		p.i++
+	}
+	return p.block[p.i], true
+}
+
+func (c packedCodec) Merge(a, b []byte) []byte {
+	countA, lastA, pos := readHeader(a)
+	countB, lastB, _ := readHeader(b)
+	if countA == 0 {
+		return append([]byte{}, b...)
+	}
+	if countB == 0 {
+		return append([]byte{}, a...)
+	}
+
+	idsB := c.Decode(b)
+	if idsB[0] <= lastA {
+		return c.Encode(union(c.Decode(a), idsB))
+	}
+
+	// b goes after a: only a's last block needs to be packed again
+	prevLast := uint64(0)
+	for {
+		h := readBlockHeader(a, pos, prevLast)
+		if h.end >= len(a) {
+			ids := h.decode(a, make([]uint64, 0, h.n+len(idsB)))
+			ret := appendHeader(make([]byte, 0, len(a)+len(b)), int(countA+countB), lastB)
+			ret = append(ret, a[len(appendHeader(nil, int(countA), lastA)):pos]...)
+			return encodeBlocks(ret, append(ids, idsB...), prevLast)
+		}
+		pos, prevLast = h.end, h.last
+	}
+}
@@ -0,0 +1,157 @@
+// Posting lists: ascending lists of distinct record IDs, compressed.
+// Lists start with the number of IDs and the last one, so that
+// appending to a list does not have to decode it. Codec.Merge has the
+// signature of btree.Options.AppendFunc, so that Btree.Append keeps
+// lists compressed as they grow:
+//
+//	btree.NewInMemoryBtreeOptions(btree.Options{AppendFunc: postings.Delta.Merge})
+//	...
+//	index.Append(key, postings.Delta.Encode([]uint64{id}))
+package postings
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type Codec interface {
+	// Encode ids, which must be ascending and distinct.
+	Encode(ids []uint64) []byte
+	Decode(list []byte) []uint64
+	// Decode lazily.
+	Iter(list []byte) Iterator
+	// The union of two lists. Cheap if the IDs in b come after
+	// those in a.
+	Merge(a, b []byte) []byte
+}
+
+type Iterator interface {
+	// The next ID. ok is false when done.
+	Next() (id uint64, ok bool)
+	// Move to the first ID >= target, and return it. Never moves
+	// backwards: if the current ID is already >= target, that is
+	// returned again.
+	SkipTo(target uint64) (id uint64, ok bool)
+}
+
+// Number of IDs in a list, from its header.
+func Len(list []byte) int {
+	count, _, _ := readHeader(list)
+	return int(count)
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
+
+func uvarint(data []byte, pos int) (uint64, int) {
+	v, n := binary.Uvarint(data[pos:])
+	if n <= 0 {
+		panic(fmt.Sprint("postings: corrupt list at ", pos))
+	}
+	return v, pos + n
+}
+
+// count, last ID and where the body starts
+func readHeader(list []byte) (count uint64, last uint64, body int) {
+	if len(list) == 0 {
+		return 0, 0, 0
+	}
+	count, pos := uvarint(list, 0)
+	last, pos = uvarint(list, pos)
+	return count, last, pos
+}
+
+func appendHeader(dst []byte, count int, last uint64) []byte {
+	return appendUvarint(appendUvarint(dst, uint64(count)), last)
+}
+
+func checkSorted(ids []uint64) {
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			panic(fmt.Sprint("postings: ids must be ascending and distinct, got ", ids[i-1], " then ", ids[i]))
+		}
+	}
+}
+
+// Union of two ascending lists of distinct IDs.
+func union(a, b []uint64) []uint64 {
+	ret := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			ret = append(ret, a[i])
+			i++
+		case a[i] > b[j]:
+			ret = append(ret, b[j])
+			j++
+		default:
+			ret = append(ret, a[i])
+			i++
+			j++
+		}
+	}
+	ret = append(ret, a[i:]...)
+	return append(ret, b[j:]...)
+}
+
+// Iterator over decoded IDs. SkipTo gallops.
+type sliceIter struct {
+	ids []uint64
+	pos int
+}
+
+// Iterator over IDs that are already decoded, which must be
+// ascending.
+func SliceIter(ids []uint64) Iterator {
+	return &sliceIter{ids, -1}
+}
+
+func (s *sliceIter) Next() (uint64, bool) {
+	if s.pos+1 >= len(s.ids) {
+		s.pos = len(s.ids)
+		return 0, false
+	}
+	s.pos++
+	return s.ids[s.pos], true
+}
+
+func (s *sliceIter) SkipTo(target uint64) (uint64, bool) {
+	if s.pos < 0 {
+		s.pos = 0
+	}
+	if s.pos >= len(s.ids) {
+		return 0, false
+	}
+	if s.ids[s.pos] >= target {
+		return s.ids[s.pos], true
+	}
+
+	// gallop to find a bound, then binary search behind it
+	lo, step := s.pos, 1
+	hi := lo + step
+	for hi < len(s.ids) && s.ids[hi] < target {
+		lo = hi
+		step *= 2
+		hi = lo + step
+	}
+	if hi > len(s.ids) {
+		hi = len(s.ids)
+	}
+	for lo+1 < hi {
+		mid := (lo + hi) / 2
+		if s.ids[mid] < target {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	s.pos = hi
+	if s.pos >= len(s.ids) {
+		return 0, false
+	}
+	return s.ids[s.pos], true
+}
@@ -0,0 +1,198 @@
+package postings
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/avisagie/indexes/btree"
+)
+
+var codecs = map[string]Codec{"delta": Delta, "packed": Packed}
+
+func randomIDs(r *rand.Rand, n int, maxGap uint64) []uint64 {
+	ret := make([]uint64, 0, n)
+	id := uint64(0)
+	for i := 0; i < n; i++ {
+		id += 1 + uint64(r.Int63n(int64(maxGap)))
+		ret = append(ret, id)
+	}
+	return ret
+}
+
+func TestEncodeDecode(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	lists := [][]uint64{
+		{},
+		{0},
+		{1<<64 - 1},
+		{0, 1, 2, 3},
+		{5, 1 << 40, 1<<64 - 1},
+		randomIDs(r, 127, 3),
+		randomIDs(r, 128, 1000),
+		randomIDs(r, 129, 1<<20),
+		randomIDs(r, 10000, 100),
+	}
+	for name, c := range codecs {
+		for _, ids := range lists {
+			list := c.Encode(ids)
+			if Len(list) != len(ids) {
+				t.Fatal(name, "expected length", len(ids), "got", Len(list))
+			}
+			got := c.Decode(list)
+			if !reflect.DeepEqual(got, ids) {
+				t.Fatal(name, "expected", ids, "got", got)
+			}
+
+			it := c.Iter(list)
+			for _, id := range ids {
+				if got, ok := it.Next(); !ok || got != id {
+					t.Fatal(name, "expected", id, "got", got, ok)
+				}
+			}
+			if _, ok := it.Next(); ok {
+				t.Fatal(name, "expected the end")
+			}
+		}
+	}
+}
+
+func TestEncodeUnsorted(t *testing.T) {
+	for name, c := range codecs {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error(name, "expected a panic")
+				}
+			}()
+			c.Encode([]uint64{1, 3, 3})
+		}()
+	}
+}
+
+func TestSkipTo(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	ids := randomIDs(r, 5000, 50)
+	iters := map[string]func() Iterator{
+		"slice": func() Iterator { return SliceIter(ids) },
+	}
+	for name, c := range codecs {
+		list := c.Encode(ids)
+		c := c
+		iters[name] = func() Iterator { return c.Iter(list) }
+	}
+
+	for name, iter := range iters {
+		it := iter()
+		target := uint64(0)
+		for {
+			target += uint64(r.Int63n(500))
+			want := -1
+			for i, id := range ids {
+				if id >= target {
+					want = i
+					break
+				}
+			}
+
+			got, ok := it.SkipTo(target)
+			if want < 0 {
+				if ok {
+					t.Fatal(name, "expected the end after", target, "got", got)
+				}
+				break
+			}
+			if !ok || got != ids[want] {
+				t.Fatal(name, "skipping to", target, "expected", ids[want], "got", got, ok)
+			}
+
+			// SkipTo does not move past the current ID
+			if again, _ := it.SkipTo(target); again != got {
+				t.Fatal(name, "expected to stay at", got, "got", again)
+			}
+			if want+1 < len(ids) {
+				if next, ok := it.Next(); !ok || next != ids[want+1] {
+					t.Fatal(name, "expected", ids[want+1], "after", got, "got", next)
+				}
+				target = ids[want+1]
+			}
+		}
+		if _, ok := it.Next(); ok {
+			t.Fatal(name, "expected the end")
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for name, c := range codecs {
+		for i := 0; i < 100; i++ {
+			a := randomIDs(r, r.Intn(300), 10)
+			b := randomIDs(r, r.Intn(300), 10)
+			if i%2 == 0 && len(a) > 0 {
+				// the common case: b comes after a
+				for j := range b {
+					b[j] += a[len(a)-1]
+				}
+			}
+
+			want := union(a, b)
+			list := c.Merge(c.Encode(a), c.Encode(b))
+			if got := c.Decode(list); !reflect.DeepEqual(got, want) {
+				t.Fatal(name, "expected", want, "got", got)
+			}
+			if Len(list) != len(want) {
+				t.Fatal(name, "expected length", len(want), "got", Len(list))
+			}
+			if !reflect.DeepEqual(list, c.Encode(want)) {
+				t.Fatal(name, "expected merging to encode like Encode")
+			}
+		}
+	}
+}
+
+func TestBtreeAppend(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for name, c := range codecs {
+		index := btree.NewInMemoryBtreeOptions(btree.Options{AppendFunc: c.Merge})
+		defer index.Dispose()
+
+		want := make(map[string][]uint64)
+		for id := uint64(0); id < 20000; id++ {
+			key := []string{"GET", "PUT", "POST", "DELETE"}[r.Intn(4)]
+			index.Append([]byte(key), c.Encode([]uint64{id}))
+			want[key] = append(want[key], id)
+		}
+
+		for key, ids := range want {
+			list, ok := index.Get([]byte(key))
+			if !ok {
+				t.Fatal(name, "expected", key)
+			}
+			if got := c.Decode(list); !reflect.DeepEqual(got, ids) {
+				t.Fatal(name, key, "expected", len(ids), "ids, got", len(got))
+			}
+			if len(list) > 3*len(ids) {
+				t.Error(name, key, "expected the list to stay compressed, got", len(list), "bytes for", len(ids), "ids")
+			}
+		}
+	}
+}
+
+func BenchmarkSkipTo(b *testing.B) {
+	r := rand.New(rand.NewSource(5))
+	ids := randomIDs(r, 100000, 20)
+	for name, c := range codecs {
+		list := c.Encode(ids)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				it := c.Iter(list)
+				for target := uint64(0); ; target += 1000 {
+					if _, ok := it.SkipTo(target); !ok {
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package query
+
+import (
+	"container/heap"
+	"math"
+
+	"github.com/avisagie/indexes/postings"
+)
+
+// Intersection: each iterator in turn skips to the largest ID seen
+// so far, until they all agree. SkipTo lets the iterators gallop over
+// the IDs in between. IDs in any of excluded are left out.
+type intersection struct {
+	its      []postings.Iterator
+	excluded []postings.Iterator
+	cur      uint64
+	started  bool
+	done     bool
+}
+
+func (x *intersection) Next() (uint64, bool) {
+	if x.done {
+		return 0, false
+	}
+	if !x.started {
+		return x.from(0)
+	}
+	if x.cur == math.MaxUint64 {
+		x.done = true
+		return 0, false
+	}
+	return x.from(x.cur + 1)
+}
+
+func (x *intersection) SkipTo(target uint64) (uint64, bool) {
+	if x.done {
+		return 0, false
+	}
+	if x.started && x.cur >= target {
+		return x.cur, true
+	}
+	return x.from(target)
+}
+
+// The first match >= target.
+func (x *intersection) from(target uint64) (uint64, bool) {
+	x.started = true
+	for i := 0; i < len(x.its); {
+		id, ok := x.its[i].SkipTo(target)
+		if !ok {
+			x.done = true
+			return 0, false
+		}
+		if id > target {
+			// start over with the new candidate
+			target = id
+			if i > 0 {
+				i = 0
+				continue
+			}
+		}
+		i++
+		if i == len(x.its) && x.isExcluded(target) {
+			if target == math.MaxUint64 {
+				x.done = true
+				return 0, false
+			}
+			target++
+			i = 0
+		}
+	}
+	x.cur = target
+	return target, true
+}
+
+func (x *intersection) isExcluded(id uint64) bool {
+	for _, it := range x.excluded {
+		if e, ok := it.SkipTo(id); ok && e == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Union: a heap of the iterators ordered by their current IDs.
+type union struct {
+	h       iterHeap
+	its     []postings.Iterator
+	cur     uint64
+	started bool
+	done    bool
+}
+
+type heapEntry struct {
+	id uint64
+	it postings.Iterator
+}
+
+type iterHeap []heapEntry
+
+func (h iterHeap) Len() int            { return len(h) }
+func (h iterHeap) Less(i, j int) bool  { return h[i].id < h[j].id }
+func (h iterHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *iterHeap) Push(x interface{}) { *h = append(*h, x.(heapEntry)) }
+func (h *iterHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+func newUnion(its []postings.Iterator) postings.Iterator {
+	if len(its) == 1 {
+		return its[0]
+	}
+	return &union{its: its}
+}
+
+// Fill the heap with the first ID >= target from each iterator.
+func (u *union) start(target uint64) {
+	u.started = true
+	u.h = make(iterHeap, 0, len(u.its))
+	for _, it := range u.its {
+		if id, ok := it.SkipTo(target); ok {
+			u.h = append(u.h, heapEntry{id, it})
+		}
+	}
+	heap.Init(&u.h)
+	u.its = nil
+}
+
+// Take the smallest ID off the heap as the current one.
+func (u *union) top() (uint64, bool) {
+	if len(u.h) == 0 {
+		u.done = true
+		return 0, false
+	}
+	u.cur = u.h[0].id
+	return u.cur, true
+}
+
+func (u *union) Next() (uint64, bool) {
+	if u.done {
+		return 0, false
+	}
+	if !u.started {
+		u.start(0)
+		return u.top()
+	}
+
+	// move everything at the current ID on
+	for len(u.h) > 0 && u.h[0].id == u.cur {
+		if id, ok := u.h[0].it.Next(); ok {
+			u.h[0].id = id
+			heap.Fix(&u.h, 0)
+		} else {
+			heap.Pop(&u.h)
+		}
+	}
+	return u.top()
+}
+
+func (u *union) SkipTo(target uint64) (uint64, bool) {
+	if u.done {
+		return 0, false
+	}
+	if !u.started {
+		u.start(target)
+		return u.top()
+	}
+	if u.cur >= target {
+		return u.cur, true
+	}
+
+	for len(u.h) > 0 && u.h[0].id < target {
+		if id, ok := u.h[0].it.SkipTo(target); ok {
+			u.h[0].id = id
+			heap.Fix(&u.h, 0)
+		} else {
+			heap.Pop(&u.h)
+		}
+	}
+	return u.top()
+}
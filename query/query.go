@@ -0,0 +1,219 @@
+// Boolean queries over fields indexed by posting lists. A field is an
+// index from the values of a field to lists of the IDs of the records
+// with that value, encoded by a postings.Codec. Leaves select the IDs
+// for a key, a prefix or a range of keys of a field, and And, Or and
+// Not combine them:
+//
+//	host := query.Field{"host", hostIndex, postings.Delta}
+//	status := query.Field{"status", statusIndex, postings.Delta}
+//	q := query.And(host.Prefix([]byte("web")), query.Not(status.Term([]byte("200"))))
+//	ids := query.Collect(q.Iter())
+//
+// Everything is lazy: posting lists are decoded as the iterator
+// returned by Iter gets to them, and intersections skip ahead in the
+// lists instead of decoding all of them.
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/postings"
+)
+
+type Expr interface {
+	// The IDs of the matching records, in order.
+	Iter() postings.Iterator
+	String() string
+}
+
+// An index from field values to posting lists.
+type Field struct {
+	Name  string
+	Index indexes.ROIndex
+	Codec postings.Codec
+}
+
+// Records with exactly this value.
+func (f Field) Term(key []byte) Expr {
+	return &term{f, key}
+}
+
+// Records with values that start with prefix.
+func (f Field) Prefix(prefix []byte) Expr {
+	return &prefixMatch{f, prefix}
+}
+
+// Records with values v such that start <= v < end. A nil end means
+// no upper bound.
+func (f Field) Range(start, end []byte) Expr {
+	return &keyRange{f, start, end}
+}
+
+// Records that match all of exprs. Not expressions among them exclude
+// records. At least one of exprs must not be a Not.
+func And(exprs ...Expr) Expr {
+	if len(exprs) == 0 {
+		panic("query: And of nothing")
+	}
+	return &and{exprs}
+}
+
+// Records that match any of exprs.
+func Or(exprs ...Expr) Expr {
+	return &or{exprs}
+}
+
+// Records that do not match e. Only valid inside an And: there is no
+// list of all records to take e away from.
+func Not(e Expr) Expr {
+	return &not{e}
+}
+
+// All the IDs from it.
+func Collect(it postings.Iterator) []uint64 {
+	ret := make([]uint64, 0)
+	for {
+		id, ok := it.Next()
+		if !ok {
+			return ret
+		}
+		ret = append(ret, id)
+	}
+}
+
+type term struct {
+	field Field
+	key   []byte
+}
+
+func (t *term) Iter() postings.Iterator {
+	list, ok := t.field.Index.Get(t.key)
+	if !ok {
+		return postings.SliceIter(nil)
+	}
+	return t.field.Codec.Iter(list)
+}
+
+func (t *term) String() string {
+	return fmt.Sprintf("%s=%q", t.field.Name, t.key)
+}
+
+type prefixMatch struct {
+	field  Field
+	prefix []byte
+}
+
+func (p *prefixMatch) Iter() postings.Iterator {
+	its := make([]postings.Iterator, 0)
+	scan := p.field.Index.Start(p.prefix)
+	for {
+		_, list, ok := scan.Next()
+		if !ok {
+			break
+		}
+		its = append(its, p.field.Codec.Iter(list))
+	}
+	return newUnion(its)
+}
+
+func (p *prefixMatch) String() string {
+	return fmt.Sprintf("%s=%q*", p.field.Name, p.prefix)
+}
+
+type keyRange struct {
+	field      Field
+	start, end []byte
+}
+
+func (r *keyRange) Iter() postings.Iterator {
+	// scan the keys that start with what start and end have in
+	// common, from start
+	common := 0
+	if r.end != nil {
+		for common < len(r.start) && common < len(r.end) && r.start[common] == r.end[common] {
+			common++
+		}
+	}
+
+	its := make([]postings.Iterator, 0)
+	scan := r.field.Index.Start(r.start[:common])
+	for {
+		key, list, ok := scan.Next()
+		if !ok || (r.end != nil && string(key) >= string(r.end)) {
+			break
+		}
+		if string(key) >= string(r.start) {
+			its = append(its, r.field.Codec.Iter(list))
+		}
+	}
+	return newUnion(its)
+}
+
+func (r *keyRange) String() string {
+	if r.end == nil {
+		return fmt.Sprintf("%s>=%q", r.field.Name, r.start)
+	}
+	return fmt.Sprintf("%q<=%s<%q", r.start, r.field.Name, r.end)
+}
+
+type and struct {
+	exprs []Expr
+}
+
+func (a *and) Iter() postings.Iterator {
+	its := make([]postings.Iterator, 0, len(a.exprs))
+	excluded := make([]postings.Iterator, 0)
+	for _, e := range a.exprs {
+		if n, ok := e.(*not); ok {
+			excluded = append(excluded, n.expr.Iter())
+		} else {
+			its = append(its, e.Iter())
+		}
+	}
+	if len(its) == 0 {
+		panic("query: And needs something besides Not")
+	}
+	return &intersection{its: its, excluded: excluded}
+}
+
+func (a *and) String() string {
+	return join(a.exprs, " AND ")
+}
+
+type or struct {
+	exprs []Expr
+}
+
+func (o *or) Iter() postings.Iterator {
+	its := make([]postings.Iterator, len(o.exprs))
+	for i, e := range o.exprs {
+		its[i] = e.Iter()
+	}
+	return newUnion(its)
+}
+
+func (o *or) String() string {
+	return join(o.exprs, " OR ")
+}
+
+type not struct {
+	expr Expr
+}
+
+func (n *not) Iter() postings.Iterator {
+	panic("query: Not is only valid inside And")
+}
+
+func (n *not) String() string {
+	return "NOT " + n.expr.String()
+}
+
+func join(exprs []Expr, sep string) string {
+	s := make([]string, len(exprs))
+	for i, e := range exprs {
+		s[i] = e.String()
+	}
+	return "(" + strings.Join(s, sep) + ")"
+}
@@ -0,0 +1,158 @@
+package query
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/avisagie/indexes/btree"
+	"github.com/avisagie/indexes/postings"
+)
+
+type record struct {
+	host   string
+	status string
+}
+
+// Indexes on host and status of n random records, and the records.
+func fields(n int, codec postings.Codec) (host, status Field, records []record) {
+	r := rand.New(rand.NewSource(1))
+	hosts := []string{"db1", "db2", "web1", "web2", "web3", "web10"}
+	statuses := []string{"200", "201", "302", "404", "500", "503"}
+
+	opts := btree.Options{AppendFunc: codec.Merge}
+	host = Field{"host", btree.NewInMemoryBtreeOptions(opts), codec}
+	status = Field{"status", btree.NewInMemoryBtreeOptions(opts), codec}
+	for id := 0; id < n; id++ {
+		// skip some IDs so that there are gaps
+		if r.Intn(5) == 0 {
+			records = append(records, record{})
+			continue
+		}
+		rec := record{hosts[r.Intn(len(hosts))], statuses[r.Intn(len(statuses))]}
+		records = append(records, rec)
+		list := codec.Encode([]uint64{uint64(id)})
+		host.Index.(*btree.Btree).Append([]byte(rec.host), list)
+		status.Index.(*btree.Btree).Append([]byte(rec.status), list)
+	}
+	return
+}
+
+func matching(records []record, f func(record) bool) []uint64 {
+	ret := make([]uint64, 0)
+	for id, rec := range records {
+		if rec.host != "" && f(rec) {
+			ret = append(ret, uint64(id))
+		}
+	}
+	return ret
+}
+
+func TestQueries(t *testing.T) {
+	for _, codec := range []postings.Codec{postings.Delta, postings.Packed} {
+		host, status, records := fields(5000, codec)
+		defer host.Index.Dispose()
+		defer status.Index.Dispose()
+
+		queries := []struct {
+			q    Expr
+			want func(record) bool
+		}{
+			{host.Term([]byte("web1")), func(r record) bool { return r.host == "web1" }},
+			{host.Term([]byte("web4")), func(r record) bool { return false }},
+			{host.Prefix([]byte("web1")), func(r record) bool { return r.host == "web1" || r.host == "web10" }},
+			{host.Prefix([]byte("db")), func(r record) bool { return r.host[:2] == "db" }},
+			{status.Range([]byte("300"), []byte("500")), func(r record) bool { return r.status >= "300" && r.status < "500" }},
+			{status.Range([]byte("404"), nil), func(r record) bool { return r.status >= "404" }},
+			{status.Range([]byte("201"), []byte("3")), func(r record) bool { return r.status >= "201" && r.status < "3" }},
+			{And(host.Term([]byte("web2")), status.Term([]byte("500"))),
+				func(r record) bool { return r.host == "web2" && r.status == "500" }},
+			{And(host.Prefix([]byte("web")), status.Range([]byte("5"), nil), Not(host.Term([]byte("web3")))),
+				func(r record) bool { return r.host[:3] == "web" && r.status >= "5" && r.host != "web3" }},
+			{Or(host.Term([]byte("db1")), status.Term([]byte("302"))),
+				func(r record) bool { return r.host == "db1" || r.status == "302" }},
+			{Or(), func(r record) bool { return false }},
+			{And(Or(host.Term([]byte("db1")), host.Term([]byte("web2"))), Not(Or(status.Term([]byte("200")), status.Term([]byte("201"))))),
+				func(r record) bool { return (r.host == "db1" || r.host == "web2") && r.status != "200" && r.status != "201" }},
+			{Or(And(host.Term([]byte("db2")), status.Term([]byte("200"))), And(host.Term([]byte("web1")), status.Term([]byte("200")))),
+				func(r record) bool { return (r.host == "db2" || r.host == "web1") && r.status == "200" }},
+		}
+
+		for _, q := range queries {
+			want := matching(records, q.want)
+			got := Collect(q.q.Iter())
+			if !reflect.DeepEqual(got, want) {
+				t.Fatal(q.q, "expected", len(want), "IDs, got", len(got))
+			}
+		}
+	}
+}
+
+// SkipTo on the combined iterators agrees with skipping through
+// what Collect returns.
+func TestSkipTo(t *testing.T) {
+	host, status, _ := fields(5000, postings.Packed)
+	defer host.Index.Dispose()
+	defer status.Index.Dispose()
+
+	queries := []Expr{
+		host.Prefix([]byte("web")),
+		And(host.Prefix([]byte("web")), Not(status.Term([]byte("200")))),
+		Or(host.Term([]byte("db1")), status.Term([]byte("503"))),
+	}
+	r := rand.New(rand.NewSource(2))
+	for _, q := range queries {
+		ids := Collect(q.Iter())
+		it := q.Iter()
+		target := uint64(0)
+		for {
+			target += uint64(r.Intn(40))
+			got, ok := it.SkipTo(target)
+
+			want := postings.SliceIter(ids)
+			wantID, wantOK := want.SkipTo(target)
+			if ok != wantOK || got != wantID {
+				t.Fatal(q, "skipping to", target, "expected", wantID, wantOK, "got", got, ok)
+			}
+			if !ok {
+				break
+			}
+			if again, _ := it.SkipTo(target); again != got {
+				t.Fatal(q, "expected SkipTo to stay at", got, "got", again)
+			}
+			next, ok := it.Next()
+			if !ok {
+				break
+			}
+			target = next
+		}
+	}
+}
+
+func TestNot(t *testing.T) {
+	host, status, _ := fields(100, postings.Delta)
+	defer host.Index.Dispose()
+	defer status.Index.Dispose()
+
+	for _, q := range []Expr{Not(host.Term([]byte("db1"))), And(Not(host.Term([]byte("db1"))))} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected", q, "to panic")
+				}
+			}()
+			q.Iter()
+		}()
+	}
+}
+
+func TestString(t *testing.T) {
+	host := Field{Name: "host"}
+	status := Field{Name: "status"}
+	q := And(host.Prefix([]byte("web")), Or(status.Range([]byte("500"), []byte("600")), status.Range([]byte("404"), nil)), Not(host.Term([]byte("web3"))))
+	want := `(host="web"* AND ("500"<=status<"600" OR status>="404") AND NOT host="web3")`
+	if fmt.Sprint(q) != want {
+		t.Fatal("expected", want, "got", q)
+	}
+}
@@ -0,0 +1,331 @@
+// A skiplist in the style of the LevelDB and RocksDB memtables. Keys,
+// values and the nodes themselves live in a malloc.Region, so that
+// the garbage collector does not see millions of little objects.
+// Safe for concurrent use: puts take a write lock, gets and iterators
+// a read lock.
+package skiplist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/malloc"
+)
+
+const (
+	maxHeight = 12
+	// one in branching nodes at a level is also on the next
+	branching = 4
+)
+
+// Nodes are laid out in the region as:
+//
+//	int64   reference to the value
+//	uint32  length of the key
+//	uint8   height
+//	        padding to 8 bytes
+//	int64   reference to the next node, for each level up to height
+//	        the key
+//
+// Values are a uvarint length followed by the bytes. The head node is
+// at reference 0, so 0 in a next reference means no next node.
+const (
+	valueOffset  = 0
+	keyLenOffset = 8
+	heightOffset = 12
+	nextOffset   = 16
+)
+
+// Keys are at most this long.
+const MaxKeySize = 1 << 16
+
+type Skiplist struct {
+	mu     sync.RWMutex
+	region *malloc.Region
+	head   int64
+	height int
+	size   int64
+	rnd    uint64
+}
+
+type Options struct {
+	// Where nodes, keys and values are allocated. Defaults to
+	// malloc.Default.
+	Allocator malloc.Allocator
+}
+
+func NewSkiplist() indexes.Index {
+	return NewSkiplistOptions(Options{})
+}
+
+func NewSkiplistOptions(opts Options) indexes.Index {
+	if opts.Allocator == nil {
+		opts.Allocator = malloc.Default
+	}
+	s := &Skiplist{region: malloc.NewRegion(opts.Allocator), height: 1, rnd: 0x2545f4914f6cdd1d}
+	s.head = s.newNode(nil, maxHeight, -1)
+	return s
+}
+
+func (s *Skiplist) newNode(key []byte, height int, value int64) int64 {
+	n := nextOffset + 8*height + len(key)
+	ref := s.region.Alloc(n)
+	node := s.region.Bytes(ref, n)
+	binary.LittleEndian.PutUint64(node[valueOffset:], uint64(value))
+	binary.LittleEndian.PutUint32(node[keyLenOffset:], uint32(len(key)))
+	node[heightOffset] = byte(height)
+	for i := 0; i < height; i++ {
+		binary.LittleEndian.PutUint64(node[nextOffset+8*i:], 0)
+	}
+	copy(node[nextOffset+8*height:], key)
+	return ref
+}
+
+func (s *Skiplist) header(ref int64) []byte {
+	return s.region.Bytes(ref, nextOffset)
+}
+
+func (s *Skiplist) key(ref int64) []byte {
+	h := s.header(ref)
+	height := int(h[heightOffset])
+	l := int(binary.LittleEndian.Uint32(h[keyLenOffset:]))
+	return s.region.Bytes(ref+int64(nextOffset+8*height), l)
+}
+
+func (s *Skiplist) next(ref int64, level int) int64 {
+	return int64(binary.LittleEndian.Uint64(s.region.Bytes(ref+int64(nextOffset+8*level), 8)))
+}
+
+func (s *Skiplist) setNext(ref int64, level int, next int64) {
+	binary.LittleEndian.PutUint64(s.region.Bytes(ref+int64(nextOffset+8*level), 8), uint64(next))
+}
+
+func (s *Skiplist) value(ref int64) []byte {
+	vref := int64(binary.LittleEndian.Uint64(s.header(ref)[valueOffset:]))
+	buf := s.region.Bytes(vref, binary.MaxVarintLen64)
+	l, n := binary.Uvarint(buf)
+	return s.region.Bytes(vref+int64(n), int(l))
+}
+
+// The old value stays where it is, since what Get returned may still
+// point at it. It goes with the region on Dispose.
+func (s *Skiplist) setValue(ref int64, value []byte) {
+	var hdr [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(value)))
+	// room to read a whole uvarint header back, however short
+	vref := s.region.Alloc(binary.MaxVarintLen64 + len(value))
+	buf := s.region.Bytes(vref, n+len(value))
+	copy(buf, hdr[:n])
+	copy(buf[n:], value)
+	binary.LittleEndian.PutUint64(s.header(ref)[valueOffset:], uint64(vref))
+}
+
+func (s *Skiplist) randomHeight() int {
+	h := 1
+	for h < maxHeight {
+		// xorshift64
+		s.rnd ^= s.rnd << 13
+		s.rnd ^= s.rnd >> 7
+		s.rnd ^= s.rnd << 17
+		if s.rnd%branching != 0 {
+			break
+		}
+		h++
+	}
+	return h
+}
+
+// The first node with a key >= key, or 0. Fills prev, if given, with
+// the last node before it at each level.
+func (s *Skiplist) findGE(key []byte, prev *[maxHeight]int64) int64 {
+	x := s.head
+	for level := s.height - 1; ; level-- {
+		next := s.next(x, level)
+		for next != 0 && bytes.Compare(s.key(next), key) < 0 {
+			x = next
+			next = s.next(x, level)
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+		if level == 0 {
+			return next
+		}
+	}
+}
+
+func checkPut(key, value []byte) {
+	if len(key) == 0 || len(value) == 0 {
+		panic("Illegal nil key or value")
+	}
+	if len(key) > MaxKeySize {
+		panic(fmt.Sprint("skiplist: key larger than MaxKeySize: ", len(key)))
+	}
+}
+
+// Link a new node after the nodes in prev.
+func (s *Skiplist) insert(key, value []byte, prev *[maxHeight]int64) {
+	height := s.randomHeight()
+	for level := s.height; level < height; level++ {
+		prev[level] = s.head
+	}
+	if height > s.height {
+		s.height = height
+	}
+
+	ref := s.newNode(key, height, 0)
+	s.setValue(ref, value)
+	for level := 0; level < height; level++ {
+		s.setNext(ref, level, s.next(prev[level], level))
+		s.setNext(prev[level], level, ref)
+	}
+	s.size++
+}
+
+func (s *Skiplist) Put(key, value []byte) (replaced bool) {
+	checkPut(key, value)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prev [maxHeight]int64
+	x := s.findGE(key, &prev)
+	if x != 0 && bytes.Equal(s.key(x), key) {
+		s.setValue(x, value)
+		return true
+	}
+	s.insert(key, value, &prev)
+	return false
+}
+
+func (s *Skiplist) Append(key, value []byte) {
+	checkPut(key, value)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prev [maxHeight]int64
+	x := s.findGE(key, &prev)
+	if x != 0 && bytes.Equal(s.key(x), key) {
+		s.setValue(x, append(s.value(x), value...))
+		return
+	}
+	s.insert(key, value, &prev)
+}
+
+// Put a key that is larger than all the others. Only has to find the
+// last node, rather than compare its way down.
+func (s *Skiplist) PutNext(key, value []byte) {
+	checkPut(key, value)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prev [maxHeight]int64
+	x := s.head
+	for level := s.height - 1; level >= 0; level-- {
+		for next := s.next(x, level); next != 0; next = s.next(x, level) {
+			x = next
+		}
+		prev[level] = x
+	}
+	if x != s.head && bytes.Compare(s.key(x), key) >= 0 {
+		panic(fmt.Sprint("out of order put:", key))
+	}
+	s.insert(key, value, &prev)
+}
+
+func (s *Skiplist) Get(key []byte) (value []byte, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	x := s.findGE(key, nil)
+	if x == 0 || !bytes.Equal(s.key(x), key) {
+		return nil, false
+	}
+	return s.value(x), true
+}
+
+type iter struct {
+	s      *Skiplist
+	prefix []byte
+	x      int64
+	done   bool
+}
+
+// Iterate over the keys that start with prefix. Puts made while
+// iterating may or may not show up.
+func (s *Skiplist) Start(prefix []byte) indexes.Iter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &iter{s, append([]byte{}, prefix...), s.findGE(prefix, nil), false}
+}
+
+func (it *iter) Next() (key []byte, value []byte, ok bool) {
+	if it.done {
+		return
+	}
+	it.s.mu.RLock()
+	defer it.s.mu.RUnlock()
+
+	if it.x == 0 || !bytes.HasPrefix(it.s.key(it.x), it.prefix) {
+		it.done = true
+		return
+	}
+	key, value = it.s.key(it.x), it.s.value(it.x)
+	it.x = it.s.next(it.x, 0)
+	return key, value, true
+}
+
+func (s *Skiplist) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
+
+// Bytes allocated for nodes, keys and values.
+func (s *Skiplist) RegionBytes() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.region.Size()
+}
+
+// Check that the keys are in order at every level, and that every
+// level is a subsequence of the one below.
+func (s *Skiplist) CheckConsistency() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := int64(0)
+	for x := s.next(s.head, 0); x != 0; x = s.next(x, 0) {
+		count++
+	}
+	if count != s.size {
+		return fmt.Errorf("expected %d keys, found %d", s.size, count)
+	}
+
+	for level := 0; level < s.height; level++ {
+		below := s.next(s.head, 0)
+		var prev []byte
+		for x := s.next(s.head, level); x != 0; x = s.next(x, level) {
+			k := s.key(x)
+			if prev != nil && bytes.Compare(prev, k) >= 0 {
+				return fmt.Errorf("level %d: %v after %v", level, k, prev)
+			}
+			for below != 0 && below != x {
+				below = s.next(below, 0)
+			}
+			if below == 0 {
+				return fmt.Errorf("level %d: %v is not on level 0", level, k)
+			}
+			prev = k
+		}
+	}
+	return nil
+}
+
+func (s *Skiplist) Dispose() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.region.Dispose()
+}
@@ -0,0 +1,132 @@
+package skiplist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/avisagie/indexes"
+	"github.com/avisagie/indexes/indexestest"
+	"github.com/avisagie/indexes/malloc"
+)
+
+var conformance = indexestest.Options{
+	Check: func(index indexes.ROIndex) error {
+		return index.(*Skiplist).CheckConsistency()
+	},
+	MaxKeySize: MaxKeySize,
+}
+
+func TestConformance(t *testing.T) {
+	indexestest.Run(t, NewSkiplist, conformance)
+}
+
+func TestConformanceInOrder(t *testing.T) {
+	indexestest.RunInOrder(t, func() indexestest.InOrderIndex {
+		return NewSkiplist().(*Skiplist)
+	}, conformance)
+}
+
+func FuzzSkiplist(f *testing.F) {
+	indexestest.Fuzz(f, NewSkiplist, conformance)
+}
+
+func TestLargeValues(t *testing.T) {
+	index := NewSkiplist()
+	defer index.Dispose()
+	big := make([]byte, 3*malloc.RegionChunkSize+5)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	index.Put([]byte{1}, big)
+	index.Put([]byte{2}, []byte{2})
+	index.Append([]byte{2}, big)
+
+	if v, ok := index.Get([]byte{1}); !ok || !bytes.Equal(v, big) {
+		t.Fatal("Expected", len(big), "bytes, got", len(v))
+	}
+	if v, ok := index.Get([]byte{2}); !ok || len(v) != len(big)+1 || !bytes.Equal(v[1:], big) {
+		t.Fatal("Expected", len(big)+1, "bytes, got", len(v))
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	index := NewSkiplist().(*Skiplist)
+	defer index.Dispose()
+
+	const writers, perWriter = 4, 5000
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				k := make([]byte, 8)
+				binary.BigEndian.PutUint32(k, uint32(i))
+				binary.BigEndian.PutUint32(k[4:], uint32(w))
+				index.Put(k, k)
+			}
+		}(w)
+	}
+
+	// readers see keys in order while the writers go
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				var prev []byte
+				it := index.Start(nil)
+				for {
+					k, v, ok := it.Next()
+					if !ok {
+						break
+					}
+					if prev != nil && bytes.Compare(prev, k) >= 0 || !bytes.Equal(k, v) {
+						t.Error("Out of order or wrong value:", prev, k, v)
+						return
+					}
+					prev = k
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if index.Size() != writers*perWriter {
+		t.Fatal("Expected", writers*perWriter, "keys, got", index.Size())
+	}
+	if err := index.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDisposeFreesEverything(t *testing.T) {
+	alloc := malloc.NewDebug(malloc.Default)
+	index := NewSkiplistOptions(Options{Allocator: alloc})
+	for i := 0; i < 100000; i++ {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, uint64(i)*7919)
+		index.Put(k, k)
+	}
+	index.Put([]byte{1}, make([]byte, 2*malloc.RegionChunkSize))
+
+	index.Dispose()
+	if count, bytes := alloc.Live(); count != 0 {
+		alloc.Report(os.Stderr)
+		t.Fatal("Leaked", count, "allocations,", bytes, "bytes")
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	index := NewSkiplist()
+	defer index.Dispose()
+	k := make([]byte, 8)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.BigEndian.PutUint64(k, uint64(i)*0x9e3779b97f4a7c15)
+		index.Put(k, k)
+	}
+}